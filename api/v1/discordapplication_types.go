@@ -0,0 +1,54 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the Secret referred to by DiscordApplicationSpec.SecretRef.
+const (
+	SecretKeyApplicationID = "applicationID"
+	SecretKeyToken         = "token"
+	SecretKeyPublicKey     = "publicKey"
+)
+
+// DiscordApplicationSpec defines the desired state of DiscordApplication.
+type DiscordApplicationSpec struct {
+	// SecretRef refers to the Secret in the same namespace holding the
+	// credentials of the application. The Secret must have the keys
+	// "applicationID", "token" and "publicKey" (hex-encoded). "publicKey"
+	// may list several keys separated by commas to accept all of them while
+	// the key is being rotated. Changes to the Secret take effect without a
+	// restart.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// DiscordApplicationStatus defines the observed state of DiscordApplication.
+type DiscordApplicationStatus struct {
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// DiscordApplication is the Schema for the discordapplications API. Its
+// interactions are served at /webhook/<namespace>/<name>.
+type DiscordApplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DiscordApplicationSpec   `json:"spec,omitempty"`
+	Status DiscordApplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DiscordApplicationList contains a list of DiscordApplication.
+type DiscordApplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DiscordApplication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DiscordApplication{}, &DiscordApplicationList{})
+}
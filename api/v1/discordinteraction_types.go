@@ -2,20 +2,411 @@ package v1
 
 import (
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// +kubebuilder:validation:XValidation:rule="[has(self.jobTemplate), has(self.cronJobRef), has(self.resource), has(self.operation), has(self.http), has(self.reply)].filter(x, x).size() == 1",message="exactly one of jobTemplate, cronJobRef, resource, operation, http and reply must be specified"
 type DiscordInteractionActionInline struct {
-	JobTemplate batchv1.JobTemplateSpec `json:"jobTemplate"`
+	// JobTemplate is the template of the Job created for each run.
+	// +optional
+	JobTemplate *batchv1.JobTemplateSpec `json:"jobTemplate,omitempty"`
+
+	// CronJobRef refers to the CronJob in the same namespace whose
+	// jobTemplate is used to create the Job for each run, like
+	// "kubectl create job --from=cronjob/<name>".
+	// +optional
+	CronJobRef *corev1.LocalObjectReference `json:"cronJobRef,omitempty"`
+
+	// Resource creates an arbitrary object for each run instead of a Job.
+	// +optional
+	Resource *DiscordInteractionActionResource `json:"resource,omitempty"`
+
+	// Operation acts directly on an object through the controller instead
+	// of running a Job.
+	// +optional
+	Operation *DiscordInteractionActionOperation `json:"operation,omitempty"`
+
+	// HTTP calls an HTTP endpoint instead of running a Job.
+	// +optional
+	HTTP *DiscordInteractionActionHTTP `json:"http,omitempty"`
+
+	// Reply answers the command immediately with a message instead of
+	// running a Job.
+	// +optional
+	Reply *DiscordInteractionActionReply `json:"reply,omitempty"`
+}
+
+// DiscordInteractionActionReply answers the command with a message.
+// +kubebuilder:validation:XValidation:rule="has(self.content) || has(self.configMapKeyRef)",message="content or configMapKeyRef must be specified"
+type DiscordInteractionActionReply struct {
+	// Content is the Go template of the message. The template can refer to
+	// .User.ID, .User.Username, .GuildID, .ChannelID, .Options, which maps
+	// the names of the command options to their values, and .Value, which
+	// is the value read from ConfigMapKeyRef. If empty, .Value is the
+	// message.
+	// +optional
+	Content string `json:"content,omitempty"`
+
+	// ConfigMapKeyRef refers to the key of the ConfigMap in the same
+	// namespace whose value is used in the message.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// DiscordInteractionActionHTTP POSTs the context of the interaction to an
+// endpoint as JSON, and relays the reply of the endpoint to Discord as the
+// follow-up message. The reply is a JSON object that may have "content",
+// "embeds" and "ephemeral". The content is truncated to 2000 bytes. Every
+// request has the Idempotency-Key header of the ID of the interaction, which
+// is the same across the retries.
+type DiscordInteractionActionHTTP struct {
+	// URL is the URL of the endpoint.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// TimeoutSeconds is the timeout of each request. Defaults to 10. The
+	// maximums of this and Retries keep all the attempts within the 15
+	// minutes in which the interaction can be followed up.
+	// +optional
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=60
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// Retries is the number of retries after a request fails with a network
+	// error or a 5xx or 429 status. Defaults to 2.
+	// +optional
+	// +kubebuilder:default=2
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=5
+	Retries *int32 `json:"retries,omitempty"`
+
+	// SigningSecretRef refers to the key of the Secret in the same namespace
+	// used to sign the requests. If specified, the requests have the
+	// X-Vahkane-Timestamp header of the Unix time and the
+	// X-Vahkane-Signature header of "sha256=" followed by the hex-encoded
+	// HMAC-SHA256 of the timestamp, ".", and the body.
+	// +optional
+	SigningSecretRef *corev1.SecretKeySelector `json:"signingSecretRef,omitempty"`
+}
+
+// OperationType is the type of DiscordInteractionActionOperation.
+// +kubebuilder:validation:Enum=RolloutRestart;Scale;Annotate
+type OperationType string
+
+const (
+	// OperationTypeRolloutRestart restarts the pods of the target like
+	// "kubectl rollout restart".
+	OperationTypeRolloutRestart OperationType = "RolloutRestart"
+	// OperationTypeScale sets the replicas of the target.
+	OperationTypeScale OperationType = "Scale"
+	// OperationTypeAnnotate sets an annotation of the target, such as a
+	// reconcile trigger of Flux or Argo CD.
+	OperationTypeAnnotate OperationType = "Annotate"
+)
+
+// DiscordInteractionActionOperation acts on an object in the namespace of the
+// DiscordInteraction by patching it, and its result is sent as the response
+// to the interaction. RolloutRestart and Scale act on Deployments and
+// StatefulSets, which the controller is allowed to patch. Annotate acts on
+// any kind, but the controller must be allowed to patch the kinds other than
+// them.
+// +kubebuilder:validation:XValidation:rule="self.type == 'Annotate' || (self.target.apiVersion == 'apps/v1' && self.target.kind in ['Deployment', 'StatefulSet'])",message="RolloutRestart and Scale support only Deployment and StatefulSet of apps/v1"
+// +kubebuilder:validation:XValidation:rule="self.type != 'Scale' || has(self.replicas) != has(self.replicasOption)",message="exactly one of replicas and replicasOption must be specified for Scale"
+// +kubebuilder:validation:XValidation:rule="self.type != 'Annotate' || has(self.annotation)",message="annotation must be specified for Annotate"
+type DiscordInteractionActionOperation struct {
+	Type OperationType `json:"type"`
+
+	// Target is the object to act on.
+	Target DiscordInteractionActionOperationTarget `json:"target"`
+
+	// Replicas is the number of replicas set by Scale.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// ReplicasOption is the name of the command option that gives the
+	// number of replicas set by Scale.
+	// +optional
+	ReplicasOption string `json:"replicasOption,omitempty"`
+
+	// Annotation is the annotation set by Annotate.
+	// +optional
+	Annotation *DiscordInteractionActionOperationAnnotation `json:"annotation,omitempty"`
+}
+
+// DiscordInteractionActionOperationTarget specifies the object to act on.
+// Exactly one of Name and NameOption must be specified.
+// +kubebuilder:validation:XValidation:rule="has(self.name) != has(self.nameOption)",message="exactly one of name and nameOption must be specified"
+type DiscordInteractionActionOperationTarget struct {
+	// APIVersion is the API version of the object. Defaults to "apps/v1".
+	// +optional
+	// +kubebuilder:default="apps/v1"
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind is the kind of the object, such as Deployment or StatefulSet.
+	Kind string `json:"kind"`
+
+	// Name is the name of the object.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// NameOption is the name of the command option that gives the name of
+	// the object.
+	// +optional
+	NameOption string `json:"nameOption,omitempty"`
+
+	// AllowedNames restricts the names given by NameOption. If empty, any
+	// object of the kind in the namespace can be the target.
+	// +optional
+	AllowedNames []string `json:"allowedNames,omitempty"`
+}
+
+// DiscordInteractionActionOperationAnnotation specifies the annotation set by
+// Annotate. If neither Value nor ValueOption is specified, the current time is
+// set so that every run changes the annotation.
+type DiscordInteractionActionOperationAnnotation struct {
+	Key string `json:"key"`
+
+	// Value is the value of the annotation.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// ValueOption is the name of the command option that gives the value of
+	// the annotation.
+	// +optional
+	ValueOption string `json:"valueOption,omitempty"`
+}
+
+// DiscordInteractionActionResource creates an arbitrary object, such as a
+// Tekton PipelineRun or an Argo Workflow, for each run. The controller must
+// be allowed to create, get, list, update and delete the objects.
+type DiscordInteractionActionResource struct {
+	// Template is the object to create. Its name and namespace are set by
+	// the controller.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:EmbeddedResource
+	Template runtime.RawExtension `json:"template"`
+
+	// Completion specifies how to detect that the object has finished.
+	Completion DiscordInteractionActionResourceCompletion `json:"completion"`
+}
+
+// DiscordInteractionActionResourceCompletion specifies how to detect whether
+// an object has finished and whether it has succeeded. Exactly one of
+// ConditionType and FieldPath must be specified.
+// +kubebuilder:validation:XValidation:rule="has(self.conditionType) != has(self.fieldPath)",message="exactly one of conditionType and fieldPath must be specified"
+type DiscordInteractionActionResourceCompletion struct {
+	// ConditionType is the type of the condition in status.conditions that
+	// reports the result. The object has succeeded if the condition is
+	// "True" and has failed if it is "False". For example, "Succeeded" for
+	// Tekton PipelineRuns.
+	// +optional
+	ConditionType string `json:"conditionType,omitempty"`
+
+	// FieldPath is the dot-separated path to the field that reports the
+	// state of the object, such as "status.phase" for Argo Workflows.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
+
+	// SuccessValues are the values of FieldPath meaning that the object has
+	// succeeded.
+	// +optional
+	SuccessValues []string `json:"successValues,omitempty"`
+
+	// FailureValues are the values of FieldPath meaning that the object has
+	// failed.
+	// +optional
+	FailureValues []string `json:"failureValues,omitempty"`
+}
+
+// DiscordInteractionActionLogs specifies how the logs of the Job's pod are
+// relayed to Discord when the Job finishes.
+type DiscordInteractionActionLogs struct {
+	// Container is the name of the container whose logs are read. If empty,
+	// the first container of the pod is used.
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// TailLines is the number of lines from the end of the logs to include
+	// in the completion message. If nil, the whole log is read. Either way,
+	// at most 8 MiB of the logs are read.
+	// +optional
+	TailLines *int64 `json:"tailLines,omitempty"`
+
+	// Attachment makes the logs be sent as a file attached to the
+	// completion message instead of being embedded in it. If the file
+	// fails to be sent, the logs are embedded instead.
+	// +optional
+	Attachment bool `json:"attachment,omitempty"`
+}
+
+// DiscordInteractionActionRetention specifies how long finished Jobs of the
+// action are kept. If it is not specified, Jobs are deleted as soon as their
+// results are reported.
+type DiscordInteractionActionRetention struct {
+	// SuccessfulJobsHistoryLimit is the number of successful Jobs to keep.
+	// If nil, successful Jobs are not deleted by count.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulJobsHistoryLimit *int32 `json:"successfulJobsHistoryLimit,omitempty"`
+
+	// FailedJobsHistoryLimit is the number of failed Jobs to keep.
+	// If nil, failed Jobs are not deleted by count.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	FailedJobsHistoryLimit *int32 `json:"failedJobsHistoryLimit,omitempty"`
+
+	// TTLSecondsAfterFinished is set to the Jobs' ttlSecondsAfterFinished
+	// unless their template specifies one. It must be positive, since Jobs
+	// deleted as soon as they finish may be gone before their results are
+	// reported.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// DiscordInteractionActionEphemeral specifies the messages about the runs of
+// the action that are visible only to the invoker.
+type DiscordInteractionActionEphemeral struct {
+	// Queued makes the response to the command ephemeral, such as the
+	// message that the run is queued or that it failed to be queued.
+	// +optional
+	Queued bool `json:"queued,omitempty"`
+
+	// Completed makes the message reporting a successful run ephemeral. For
+	// operation, http and reply actions, it applies to their result.
+	// +optional
+	Completed bool `json:"completed,omitempty"`
+
+	// Failed makes the message reporting a failed run ephemeral.
+	// +optional
+	Failed bool `json:"failed,omitempty"`
+}
+
+// DiscordInteractionActionRateLimit limits how often the action can be
+// invoked. Invocations over the limits are refused. The limits are enforced by
+// each replica of the controller independently.
+// +kubebuilder:validation:XValidation:rule="has(self.perUser) || has(self.guild)",message="perUser or guild must be specified"
+type DiscordInteractionActionRateLimit struct {
+	// PerUser is the maximum number of invocations by each user in Window.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	PerUser *int32 `json:"perUser,omitempty"`
+
+	// Guild is the maximum number of invocations by all users in Window.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	Guild *int32 `json:"guild,omitempty"`
+
+	// Window is the time window of the limits, such as "1m".
+	Window metav1.Duration `json:"window"`
+}
+
+// +kubebuilder:validation:Enum=String;Integer;Number;Boolean
+type ActionParameterType string
+
+const (
+	ActionParameterTypeString  ActionParameterType = "String"
+	ActionParameterTypeInteger ActionParameterType = "Integer"
+	ActionParameterTypeNumber  ActionParameterType = "Number"
+	ActionParameterTypeBoolean ActionParameterType = "Boolean"
+)
+
+// DiscordInteractionActionParameter declares an option of the command that
+// the action accepts. The options are validated against the parameters before
+// the action is run.
+type DiscordInteractionActionParameter struct {
+	// Name is the name of the option.
+	Name string `json:"name"`
+
+	// Type is the type of the value. Values of String parameters must not
+	// contain control characters such as newlines.
+	// +kubebuilder:default=String
+	// +optional
+	Type ActionParameterType `json:"type,omitempty"`
+
+	// Required rejects invocations without the option.
+	// +optional
+	Required bool `json:"required,omitempty"`
+
+	// Default is the value used if the option is not given.
+	// +optional
+	Default *string `json:"default,omitempty"`
+
+	// Pattern is a regular expression that the whole value must match.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
+
+	// Enum is the list of the allowed values.
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+
+	// Min is the minimum value of Integer and Number parameters, or the
+	// minimum length of String parameters.
+	// +optional
+	Min *int64 `json:"min,omitempty"`
+
+	// Max is the maximum value of Integer and Number parameters, or the
+	// maximum length of String parameters.
+	// +optional
+	Max *int64 `json:"max,omitempty"`
 }
 
 type DiscordInteractionAction struct {
 	Name         string                         `json:"name"`
 	ActionInline DiscordInteractionActionInline `json:"actionInline"`
 	Pattern      string                         `json:"pattern"`
+
+	// +optional
+	Logs *DiscordInteractionActionLogs `json:"logs,omitempty"`
+
+	// +optional
+	Retention *DiscordInteractionActionRetention `json:"retention,omitempty"`
+
+	// +optional
+	Ephemeral *DiscordInteractionActionEphemeral `json:"ephemeral,omitempty"`
+
+	// +optional
+	RateLimit *DiscordInteractionActionRateLimit `json:"rateLimit,omitempty"`
+
+	// Concurrent allows the runs of the action to be in progress at the same
+	// time. By default, a run is refused while another run of the action is
+	// in progress.
+	// +optional
+	Concurrent bool `json:"concurrent,omitempty"`
+
+	// Parameters declares the options of the command. If any are declared,
+	// the options are validated against them before the action is run, and
+	// options that are not declared are refused.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []DiscordInteractionActionParameter `json:"parameters,omitempty"`
+}
+
+// DiscordInteractionStatusCommand configures the built-in command that lists
+// the runs in progress.
+type DiscordInteractionStatusCommand struct {
+	// Name is the name of the slash command. Defaults to "vahkane-status".
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// DefaultStatusCommandName is the default name of the built-in status command.
+const DefaultStatusCommandName = "vahkane-status"
+
+// GetName returns the name of the status command.
+func (c *DiscordInteractionStatusCommand) GetName() string {
+	if c.Name == "" {
+		return DefaultStatusCommandName
+	}
+	return c.Name
 }
 
 // DiscordInteractionSpec defines the desired state of DiscordInteraction.
@@ -26,14 +417,100 @@ type DiscordInteractionSpec struct {
 	GuildID  string                     `json:"guildID"`
 	Actions  []DiscordInteractionAction `json:"actions"`
 	Commands []string                   `json:"commands"`
+
+	// ApplicationRef refers to the DiscordApplication in the same namespace
+	// that serves the interactions. If it is not specified, the application
+	// configured by the environment variables of the controller is used.
+	// +optional
+	ApplicationRef *corev1.LocalObjectReference `json:"applicationRef,omitempty"`
+
+	// StatusCommand enables the built-in command that lists the runs in
+	// progress. It is registered along with Commands.
+	// +optional
+	StatusCommand *DiscordInteractionStatusCommand `json:"statusCommand,omitempty"`
+
+	// MaxConcurrentJobs is the maximum number of running Jobs created for the
+	// DiscordInteraction. Objects created by resource actions are not
+	// counted. If nil, the number is not limited.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxConcurrentJobs *int32 `json:"maxConcurrentJobs,omitempty"`
+
+	// ConcurrencyLimitPolicy specifies how runs over MaxConcurrentJobs are
+	// handled. "Refuse" refuses them, and "Queue" creates their Jobs
+	// suspended and resumes them in order as running Jobs finish. The
+	// results of Jobs are reported by following up their interactions, whose
+	// tokens expire 15 minutes after the commands are sent, so the Jobs of
+	// interactions still queued by then are cancelled.
+	// Defaults to "Refuse".
+	// +optional
+	// +kubebuilder:default=Refuse
+	ConcurrencyLimitPolicy ConcurrencyLimitPolicy `json:"concurrencyLimitPolicy,omitempty"`
+
+	// HistoryLimit is the maximum number of runs recorded in the status.
+	// Defaults to 10.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+}
+
+// ConcurrencyLimitPolicy is the policy for runs over MaxConcurrentJobs.
+// +kubebuilder:validation:Enum=Refuse;Queue
+type ConcurrencyLimitPolicy string
+
+const (
+	ConcurrencyLimitPolicyRefuse ConcurrencyLimitPolicy = "Refuse"
+	ConcurrencyLimitPolicyQueue  ConcurrencyLimitPolicy = "Queue"
+)
+
+// DefaultHistoryLimit is the default value of DiscordInteractionSpec.HistoryLimit.
+const DefaultHistoryLimit = 10
+
+type RunResult string
+
+const (
+	RunResultSucceeded RunResult = "Succeeded"
+	RunResultFailed    RunResult = "Failed"
+	RunResultCancelled RunResult = "Cancelled"
+)
+
+// DiscordInteractionRunRecord is a record of a finished run of an action.
+type DiscordInteractionRunRecord struct {
+	JobName string    `json:"jobName"`
+	Action  string    `json:"action"`
+	Result  RunResult `json:"result"`
+
+	// +optional
+	UserID string `json:"userID,omitempty"`
+	// +optional
+	UserName string `json:"userName,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // DiscordInteractionStatus defines the observed state of DiscordInteraction.
 type DiscordInteractionStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// History is the list of the recent runs, newest first.
+	// +optional
+	History []DiscordInteractionRunRecord `json:"history,omitempty"`
+
+	// Conditions are the observed conditions of the DiscordInteraction.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// DiscordInteractionConditionActionsValid is the type of the condition that
+// reports whether the actions can be run. It is False if, for example, the
+// template of a reply action or the pattern of a parameter fails to parse.
+const DiscordInteractionConditionActionsValid = "ActionsValid"
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 
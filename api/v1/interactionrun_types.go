@@ -0,0 +1,111 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InteractionRunSpec defines the desired state of InteractionRun.
+type InteractionRunSpec struct {
+	// DiscordInteractionRef refers to the DiscordInteraction in the same
+	// namespace that has the action.
+	DiscordInteractionRef corev1.LocalObjectReference `json:"discordInteractionRef"`
+
+	// Action is the name of the action to run. Only actions that create a
+	// Job or an object can be run by an InteractionRun created by hand.
+	Action string `json:"action"`
+
+	// InteractionID is the ID of the Discord interaction that triggered the
+	// run. It is empty if the InteractionRun was created by hand, in which
+	// case creating it triggers the action. The token of the interaction is
+	// kept in the vahkane.anqou.net/discord-interaction-token annotation like
+	// on the Jobs of the runs until the run finishes.
+	// +optional
+	InteractionID string `json:"interactionID,omitempty"`
+
+	// ChannelID is the ID of the Discord channel where the result of the run
+	// is posted if the run has no interaction to follow up, i.e. it was
+	// created by hand. If it is empty, the result is not posted.
+	// +optional
+	ChannelID string `json:"channelID,omitempty"`
+
+	// UserID is the ID of the Discord user who invoked the action.
+	// +optional
+	UserID string `json:"userID,omitempty"`
+
+	// UserName is the name of the Discord user who invoked the action.
+	// +optional
+	UserName string `json:"userName,omitempty"`
+
+	// Options are the values of the options of the command by their names.
+	// +optional
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Queued;Running;Succeeded;Failed;Cancelled
+type InteractionRunPhase string
+
+const (
+	InteractionRunPhaseQueued    InteractionRunPhase = "Queued"
+	InteractionRunPhaseRunning   InteractionRunPhase = "Running"
+	InteractionRunPhaseSucceeded InteractionRunPhase = "Succeeded"
+	InteractionRunPhaseFailed    InteractionRunPhase = "Failed"
+	InteractionRunPhaseCancelled InteractionRunPhase = "Cancelled"
+)
+
+// InteractionRunStatus defines the observed state of InteractionRun.
+type InteractionRunStatus struct {
+	// Phase is the phase of the run. It is empty until the run is started.
+	// +optional
+	Phase InteractionRunPhase `json:"phase,omitempty"`
+
+	// JobName is the name of the Job, or the object of a resource action,
+	// created for the run.
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// Message is a human-readable reason of the phase, such as why the run
+	// couldn't be started.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Interaction",type=string,JSONPath=`.spec.discordInteractionRef.name`
+// +kubebuilder:printcolumn:name="Action",type=string,JSONPath=`.spec.action`
+// +kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.userName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Job",type=string,JSONPath=`.status.jobName`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InteractionRun is the Schema for the interactionruns API. It is the record
+// of a run of an action, created by vahkane for each accepted invocation.
+// Creating one by hand triggers the action. Finished InteractionRuns are
+// pruned by the retention of their action, or a day after they finish if the
+// action has no retention.
+type InteractionRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InteractionRunSpec   `json:"spec,omitempty"`
+	Status InteractionRunStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InteractionRunList contains a list of InteractionRun.
+type InteractionRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InteractionRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InteractionRun{}, &InteractionRunList{})
+}
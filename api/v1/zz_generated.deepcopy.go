@@ -5,16 +5,109 @@
 package v1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordApplication) DeepCopyInto(out *DiscordApplication) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordApplication.
+func (in *DiscordApplication) DeepCopy() *DiscordApplication {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordApplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiscordApplication) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordApplicationList) DeepCopyInto(out *DiscordApplicationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DiscordApplication, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordApplicationList.
+func (in *DiscordApplicationList) DeepCopy() *DiscordApplicationList {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordApplicationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiscordApplicationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordApplicationSpec) DeepCopyInto(out *DiscordApplicationSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordApplicationSpec.
+func (in *DiscordApplicationSpec) DeepCopy() *DiscordApplicationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordApplicationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordApplicationStatus) DeepCopyInto(out *DiscordApplicationStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordApplicationStatus.
+func (in *DiscordApplicationStatus) DeepCopy() *DiscordApplicationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordApplicationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscordInteraction) DeepCopyInto(out *DiscordInteraction) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteraction.
@@ -39,6 +132,33 @@ func (in *DiscordInteraction) DeepCopyObject() runtime.Object {
 func (in *DiscordInteractionAction) DeepCopyInto(out *DiscordInteractionAction) {
 	*out = *in
 	in.ActionInline.DeepCopyInto(&out.ActionInline)
+	if in.Logs != nil {
+		in, out := &in.Logs, &out.Logs
+		*out = new(DiscordInteractionActionLogs)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(DiscordInteractionActionRetention)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ephemeral != nil {
+		in, out := &in.Ephemeral, &out.Ephemeral
+		*out = new(DiscordInteractionActionEphemeral)
+		**out = **in
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(DiscordInteractionActionRateLimit)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make([]DiscordInteractionActionParameter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionAction.
@@ -51,10 +171,79 @@ func (in *DiscordInteractionAction) DeepCopy() *DiscordInteractionAction {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionEphemeral) DeepCopyInto(out *DiscordInteractionActionEphemeral) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionEphemeral.
+func (in *DiscordInteractionActionEphemeral) DeepCopy() *DiscordInteractionActionEphemeral {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionEphemeral)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionHTTP) DeepCopyInto(out *DiscordInteractionActionHTTP) {
+	*out = *in
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SigningSecretRef != nil {
+		in, out := &in.SigningSecretRef, &out.SigningSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionHTTP.
+func (in *DiscordInteractionActionHTTP) DeepCopy() *DiscordInteractionActionHTTP {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionHTTP)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscordInteractionActionInline) DeepCopyInto(out *DiscordInteractionActionInline) {
 	*out = *in
-	in.JobTemplate.DeepCopyInto(&out.JobTemplate)
+	if in.JobTemplate != nil {
+		in, out := &in.JobTemplate, &out.JobTemplate
+		*out = new(batchv1.JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CronJobRef != nil {
+		in, out := &in.CronJobRef, &out.CronJobRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Resource != nil {
+		in, out := &in.Resource, &out.Resource
+		*out = new(DiscordInteractionActionResource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Operation != nil {
+		in, out := &in.Operation, &out.Operation
+		*out = new(DiscordInteractionActionOperation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(DiscordInteractionActionHTTP)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Reply != nil {
+		in, out := &in.Reply, &out.Reply
+		*out = new(DiscordInteractionActionReply)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionInline.
@@ -67,6 +256,240 @@ func (in *DiscordInteractionActionInline) DeepCopy() *DiscordInteractionActionIn
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionLogs) DeepCopyInto(out *DiscordInteractionActionLogs) {
+	*out = *in
+	if in.TailLines != nil {
+		in, out := &in.TailLines, &out.TailLines
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionLogs.
+func (in *DiscordInteractionActionLogs) DeepCopy() *DiscordInteractionActionLogs {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionLogs)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionOperation) DeepCopyInto(out *DiscordInteractionActionOperation) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Annotation != nil {
+		in, out := &in.Annotation, &out.Annotation
+		*out = new(DiscordInteractionActionOperationAnnotation)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionOperation.
+func (in *DiscordInteractionActionOperation) DeepCopy() *DiscordInteractionActionOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionOperationAnnotation) DeepCopyInto(out *DiscordInteractionActionOperationAnnotation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionOperationAnnotation.
+func (in *DiscordInteractionActionOperationAnnotation) DeepCopy() *DiscordInteractionActionOperationAnnotation {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionOperationAnnotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionOperationTarget) DeepCopyInto(out *DiscordInteractionActionOperationTarget) {
+	*out = *in
+	if in.AllowedNames != nil {
+		in, out := &in.AllowedNames, &out.AllowedNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionOperationTarget.
+func (in *DiscordInteractionActionOperationTarget) DeepCopy() *DiscordInteractionActionOperationTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionOperationTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionParameter) DeepCopyInto(out *DiscordInteractionActionParameter) {
+	*out = *in
+	if in.Default != nil {
+		in, out := &in.Default, &out.Default
+		*out = new(string)
+		**out = **in
+	}
+	if in.Enum != nil {
+		in, out := &in.Enum, &out.Enum
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Min != nil {
+		in, out := &in.Min, &out.Min
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Max != nil {
+		in, out := &in.Max, &out.Max
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionParameter.
+func (in *DiscordInteractionActionParameter) DeepCopy() *DiscordInteractionActionParameter {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionParameter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionRateLimit) DeepCopyInto(out *DiscordInteractionActionRateLimit) {
+	*out = *in
+	if in.PerUser != nil {
+		in, out := &in.PerUser, &out.PerUser
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Guild != nil {
+		in, out := &in.Guild, &out.Guild
+		*out = new(int32)
+		**out = **in
+	}
+	out.Window = in.Window
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionRateLimit.
+func (in *DiscordInteractionActionRateLimit) DeepCopy() *DiscordInteractionActionRateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionRateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionReply) DeepCopyInto(out *DiscordInteractionActionReply) {
+	*out = *in
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionReply.
+func (in *DiscordInteractionActionReply) DeepCopy() *DiscordInteractionActionReply {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionResource) DeepCopyInto(out *DiscordInteractionActionResource) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+	in.Completion.DeepCopyInto(&out.Completion)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionResource.
+func (in *DiscordInteractionActionResource) DeepCopy() *DiscordInteractionActionResource {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionResourceCompletion) DeepCopyInto(out *DiscordInteractionActionResourceCompletion) {
+	*out = *in
+	if in.SuccessValues != nil {
+		in, out := &in.SuccessValues, &out.SuccessValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FailureValues != nil {
+		in, out := &in.FailureValues, &out.FailureValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionResourceCompletion.
+func (in *DiscordInteractionActionResourceCompletion) DeepCopy() *DiscordInteractionActionResourceCompletion {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionResourceCompletion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionActionRetention) DeepCopyInto(out *DiscordInteractionActionRetention) {
+	*out = *in
+	if in.SuccessfulJobsHistoryLimit != nil {
+		in, out := &in.SuccessfulJobsHistoryLimit, &out.SuccessfulJobsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedJobsHistoryLimit != nil {
+		in, out := &in.FailedJobsHistoryLimit, &out.FailedJobsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionActionRetention.
+func (in *DiscordInteractionActionRetention) DeepCopy() *DiscordInteractionActionRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionActionRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscordInteractionList) DeepCopyInto(out *DiscordInteractionList) {
 	*out = *in
@@ -99,6 +522,29 @@ func (in *DiscordInteractionList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionRunRecord) DeepCopyInto(out *DiscordInteractionRunRecord) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionRunRecord.
+func (in *DiscordInteractionRunRecord) DeepCopy() *DiscordInteractionRunRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionRunRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscordInteractionSpec) DeepCopyInto(out *DiscordInteractionSpec) {
 	*out = *in
@@ -114,6 +560,26 @@ func (in *DiscordInteractionSpec) DeepCopyInto(out *DiscordInteractionSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ApplicationRef != nil {
+		in, out := &in.ApplicationRef, &out.ApplicationRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.StatusCommand != nil {
+		in, out := &in.StatusCommand, &out.StatusCommand
+		*out = new(DiscordInteractionStatusCommand)
+		**out = **in
+	}
+	if in.MaxConcurrentJobs != nil {
+		in, out := &in.MaxConcurrentJobs, &out.MaxConcurrentJobs
+		*out = new(int32)
+		**out = **in
+	}
+	if in.HistoryLimit != nil {
+		in, out := &in.HistoryLimit, &out.HistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionSpec.
@@ -129,6 +595,20 @@ func (in *DiscordInteractionSpec) DeepCopy() *DiscordInteractionSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscordInteractionStatus) DeepCopyInto(out *DiscordInteractionStatus) {
 	*out = *in
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]DiscordInteractionRunRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionStatus.
@@ -140,3 +620,123 @@ func (in *DiscordInteractionStatus) DeepCopy() *DiscordInteractionStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscordInteractionStatusCommand) DeepCopyInto(out *DiscordInteractionStatusCommand) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscordInteractionStatusCommand.
+func (in *DiscordInteractionStatusCommand) DeepCopy() *DiscordInteractionStatusCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscordInteractionStatusCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InteractionRun) DeepCopyInto(out *InteractionRun) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InteractionRun.
+func (in *InteractionRun) DeepCopy() *InteractionRun {
+	if in == nil {
+		return nil
+	}
+	out := new(InteractionRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *InteractionRun) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InteractionRunList) DeepCopyInto(out *InteractionRunList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]InteractionRun, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InteractionRunList.
+func (in *InteractionRunList) DeepCopy() *InteractionRunList {
+	if in == nil {
+		return nil
+	}
+	out := new(InteractionRunList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *InteractionRunList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InteractionRunSpec) DeepCopyInto(out *InteractionRunSpec) {
+	*out = *in
+	out.DiscordInteractionRef = in.DiscordInteractionRef
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InteractionRunSpec.
+func (in *InteractionRunSpec) DeepCopy() *InteractionRunSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InteractionRunSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InteractionRunStatus) DeepCopyInto(out *InteractionRunStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InteractionRunStatus.
+func (in *InteractionRunStatus) DeepCopy() *InteractionRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InteractionRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
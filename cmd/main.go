@@ -1,23 +1,29 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	vahkaneanqounetv1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
 	"github.com/ushitora-anqou/vahkane/internal/controller"
 	"github.com/ushitora-anqou/vahkane/internal/discord"
 	"github.com/ushitora-anqou/vahkane/internal/runner"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -51,6 +57,10 @@ func doMain() error {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var discordTransport string
+	var discordGatewayURL string
+	var auditSinkSpec string
+	var workers runner.WorkerPoolOptions
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -62,6 +72,23 @@ func doMain() error {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&discordTransport, "discord-transport", "webhook",
+		"How to receive the interactions of the default Discord application: "+
+			"'webhook' serves the HTTP interactions endpoint, and 'gateway' connects to the Discord Gateway. "+
+			"DiscordApplications are always served by the webhook server.")
+	flag.StringVar(&discordGatewayURL, "discord-gateway-url", runner.DefaultGatewayURL,
+		"The URL of the Discord Gateway used by --discord-transport=gateway.")
+	flag.StringVar(&auditSinkSpec, "audit-sink", "",
+		"Where to write the audit log of the interactions: 'stdout', 'file:<path>', or an http(s) URL of a collector. "+
+			"If empty, the audit log is disabled.")
+	flag.IntVar(&workers.Workers, "interaction-workers", runner.DefaultWorkerPoolOptions.Workers,
+		"The number of interactions processed concurrently in the background, such as to create Jobs.")
+	flag.IntVar(&workers.QueueSize, "interaction-queue-size", runner.DefaultWorkerPoolOptions.QueueSize,
+		"The number of interactions waiting for a worker. Interactions are refused as busy while the queue is full.")
+	flag.DurationVar(&workers.TaskTimeout, "interaction-task-timeout", runner.DefaultWorkerPoolOptions.TaskTimeout,
+		"The timeout of processing each interaction in the background.")
+	flag.DurationVar(&workers.DrainTimeout, "interaction-drain-timeout", runner.DefaultWorkerPoolOptions.DrainTimeout,
+		"How long the interactions processed in the background are waited for on shutdown.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -70,27 +97,27 @@ func doMain() error {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	discordApplicationPublicKey, ok := os.LookupEnv("DISCORD_APPLICATION_PUBLIC_KEY")
-	if !ok {
-		return errors.New("set DISCORD_APPLICATION_PUBLIC_KEY")
-	}
-	discordApplicationPublicKeyParsed, err := hex.DecodeString(discordApplicationPublicKey)
+	defaultApplication, err := loadDefaultApplication()
 	if err != nil {
-		return fmt.Errorf("failed to parse DISCORD_APPLICATION_PUBLIC_KEY: %w", err)
+		return err
 	}
 
-	discordApplicationID, ok := os.LookupEnv("DISCORD_APPLICATION_ID")
-	if !ok {
-		return errors.New("set DISCORD_APPLICATION_ID")
+	if discordTransport != "webhook" && discordTransport != "gateway" {
+		return fmt.Errorf("unknown discord transport: %s", discordTransport)
 	}
 
-	discordToken, ok := os.LookupEnv("DISCORD_TOKEN")
-	if !ok {
-		return errors.New("set DISCORD_TOKEN")
+	auditSink, err := audit.NewSink(auditSinkSpec)
+	if err != nil {
+		return err
 	}
+	// The audit sink is stopped after the manager, which waits for the
+	// runners, so that the entries written while they stop are kept.
+	stopAuditSink := audit.Run(ctrl.LoggerInto(context.Background(), ctrl.Log), auditSink)
+	defer stopAuditSink()
 
+	// The webhook server is optional with the gateway transport.
 	discordWebhookServerListenAddr, ok := os.LookupEnv("DISCORD_WEBHOOK_SERVER_LISTEN")
-	if !ok {
+	if !ok && discordTransport == "webhook" {
 		return errors.New("set DISCORD_WEBHOOK_SERVER_LISTEN")
 	}
 
@@ -99,6 +126,30 @@ func doMain() error {
 		return errors.New("set POD_NAMESPACE")
 	}
 
+	// DISCORD_CREDENTIALS_SECRET is the name of the Secret in POD_NAMESPACE
+	// that holds the credentials of the default application. Unlike the
+	// environment variables above, a rotated Secret takes effect without a
+	// restart.
+	var defaultSecret types.NamespacedName
+	if name, ok := os.LookupEnv("DISCORD_CREDENTIALS_SECRET"); ok {
+		if defaultApplication != nil {
+			return errors.New("DISCORD_CREDENTIALS_SECRET can't be set together with " +
+				"DISCORD_APPLICATION_ID, DISCORD_TOKEN and DISCORD_APPLICATION_PUBLIC_KEY")
+		}
+		defaultSecret = types.NamespacedName{Name: name, Namespace: namespace}
+	}
+
+	// WATCH_NAMESPACES is a comma-separated list of the namespaces where
+	// DiscordInteractions are watched, or "*" to watch all namespaces. If it
+	// is not set, only POD_NAMESPACE is watched.
+	watchNamespaces := map[string]cache.Config{namespace: {}}
+	if value, ok := os.LookupEnv("WATCH_NAMESPACES"); ok {
+		watchNamespaces, err = parseWatchNamespaces(value)
+		if err != nil {
+			return fmt.Errorf("failed to parse WATCH_NAMESPACES: %w", err)
+		}
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -159,9 +210,14 @@ func doMain() error {
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
 
+		// The Secrets of Discord applications are cached, so that the
+		// credentials are not read from the API server for every
+		// interaction. Only the Secrets in the watched namespaces and the
+		// namespace of the default Secret are cached.
 		Cache: cache.Options{
-			DefaultNamespaces: map[string]cache.Config{
-				namespace: {},
+			DefaultNamespaces: watchNamespaces,
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Secret{}: {Namespaces: secretNamespaces(watchNamespaces, defaultSecret)},
 			},
 		},
 	})
@@ -169,37 +225,105 @@ func doMain() error {
 		return errors.New("unable to start manager")
 	}
 
+	applications := controller.NewApplicationResolver(
+		mgr.GetClient(),
+		defaultApplication,
+		defaultSecret,
+		discord.NewRealClient,
+	)
+
 	if err = controller.NewDiscordInteractionReconciler(
 		mgr.GetClient(),
+		mgr.GetAPIReader(),
 		mgr.GetScheme(),
-		namespace,
-		discord.NewRealClient(discordApplicationID, discordToken),
+		applications,
+		mgr.GetEventRecorderFor("discordinteraction-controller"),
+		auditSink,
 	).SetupWithManager(mgr); err != nil {
 		return errors.New("unable to create controller: DiscordInteraction")
 	}
 	// +kubebuilder:scaffold:builder
 
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("unable to create clientset: %w", err)
+	}
+
 	if err = controller.NewJobReconciler(
 		mgr.GetClient(),
+		mgr.GetAPIReader(),
 		mgr.GetScheme(),
-		namespace,
-		discord.NewRealClient(discordApplicationID, discordToken),
+		applications,
+		clientset,
+		mgr.GetEventRecorderFor("job-controller"),
+		auditSink,
 	).SetupWithManager(mgr); err != nil {
 		return errors.New("unable to create controller: Job")
 	}
 
-	err = mgr.Add(
-		runner.NewDiscordWebhookServerRunner(
+	if err = controller.NewResourceReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetScheme(),
+		applications,
+		mgr.GetEventRecorderFor("resource-controller"),
+		auditSink,
+	).SetupWithManager(mgr); err != nil {
+		return errors.New("unable to create controller: Resource")
+	}
+
+	if err = controller.NewInteractionRunReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetScheme(),
+		applications,
+		mgr.GetEventRecorderFor("interactionrun-controller"),
+	).SetupWithManager(mgr); err != nil {
+		return errors.New("unable to create controller: InteractionRun")
+	}
+
+	if err = controller.NewJobQueueReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetScheme(),
+		mgr.GetEventRecorderFor("jobqueue-controller"),
+	).SetupWithManager(mgr); err != nil {
+		return errors.New("unable to create controller: JobQueue")
+	}
+
+	var webhookServerRunner *runner.DiscordWebhookServerRunner
+	if discordWebhookServerListenAddr != "" {
+		webhookServerRunner = runner.NewDiscordWebhookServerRunner(
 			mgr.GetClient(),
-			discord.NewRealClient(discordApplicationID, discordToken),
+			mgr.GetAPIReader(),
+			applications,
+			mgr.GetEventRecorderFor("discord-webhook-server"),
+			auditSink,
+			workers,
 			mgr.GetLogger().WithName("DiscordWebhookServerRunner"),
-			discordApplicationPublicKeyParsed,
 			discordWebhookServerListenAddr,
-			namespace,
-		),
-	)
-	if err != nil {
-		return errors.New("unable to add DiscordWebhookServerRunner")
+		)
+		if err := mgr.Add(webhookServerRunner); err != nil {
+			return errors.New("unable to add DiscordWebhookServerRunner")
+		}
+	}
+
+	if discordTransport == "gateway" {
+		if defaultApplication == nil && defaultSecret == (types.NamespacedName{}) {
+			return errors.New("the gateway transport requires the default Discord application")
+		}
+		if err := mgr.Add(runner.NewDiscordGatewayRunner(
+			mgr.GetClient(),
+			mgr.GetAPIReader(),
+			applications,
+			mgr.GetEventRecorderFor("discord-gateway"),
+			auditSink,
+			workers,
+			mgr.GetLogger().WithName("DiscordGatewayRunner"),
+			discordGatewayURL,
+		)); err != nil {
+			return errors.New("unable to add DiscordGatewayRunner")
+		}
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -208,6 +332,11 @@ func doMain() error {
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		return errors.New("unable to set up ready check")
 	}
+	if webhookServerRunner != nil {
+		if err := mgr.AddReadyzCheck("webhook", webhookServerRunner.ReadyzCheck); err != nil {
+			return errors.New("unable to set up ready check for the webhook server")
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -216,3 +345,77 @@ func doMain() error {
 
 	return nil
 }
+
+// parseWatchNamespaces parses the value of WATCH_NAMESPACES. It returns nil,
+// which makes the cache watch all namespaces, if the value is "*".
+func parseWatchNamespaces(value string) (map[string]cache.Config, error) {
+	if strings.TrimSpace(value) == "*" {
+		return nil, nil
+	}
+	namespaces := map[string]cache.Config{}
+	for _, namespace := range strings.Split(value, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace != "" {
+			namespaces[namespace] = cache.Config{}
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil, errors.New("no namespace specified")
+	}
+	return namespaces, nil
+}
+
+// secretNamespaces returns the namespaces where Secrets are cached: the
+// watched namespaces, which hold the Secrets of DiscordApplications, and the
+// namespace of the default Secret. It returns nil if all namespaces are
+// watched.
+func secretNamespaces(
+	watchNamespaces map[string]cache.Config,
+	defaultSecret types.NamespacedName,
+) map[string]cache.Config {
+	if watchNamespaces == nil {
+		return nil
+	}
+	namespaces := map[string]cache.Config{}
+	for namespace := range watchNamespaces {
+		namespaces[namespace] = cache.Config{}
+	}
+	if defaultSecret.Namespace != "" {
+		namespaces[defaultSecret.Namespace] = cache.Config{}
+	}
+	return namespaces
+}
+
+// loadDefaultApplication returns the application configured by
+// DISCORD_APPLICATION_ID, DISCORD_TOKEN and DISCORD_APPLICATION_PUBLIC_KEY. It
+// returns nil if none of them is set, in which case every DiscordInteraction
+// must refer to a DiscordApplication.
+func loadDefaultApplication() (*controller.Application, error) {
+	envs := []string{"DISCORD_APPLICATION_ID", "DISCORD_TOKEN", "DISCORD_APPLICATION_PUBLIC_KEY"}
+	values := map[string]string{}
+	for _, env := range envs {
+		if value, ok := os.LookupEnv(env); ok {
+			values[env] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	for _, env := range envs {
+		if _, ok := values[env]; !ok {
+			return nil, fmt.Errorf("set %s", env)
+		}
+	}
+
+	publicKeys, err := controller.ParsePublicKeys(values["DISCORD_APPLICATION_PUBLIC_KEY"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DISCORD_APPLICATION_PUBLIC_KEY: %w", err)
+	}
+
+	return &controller.Application{
+		ID:         values["DISCORD_APPLICATION_ID"],
+		Token:      values["DISCORD_TOKEN"],
+		PublicKeys: publicKeys,
+		Client:     discord.NewRealClient(values["DISCORD_APPLICATION_ID"], values["DISCORD_TOKEN"]),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+func TestParseWatchNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]cache.Config
+		wantErr bool
+	}{
+		{name: "empty", value: "", wantErr: true},
+		{name: "only commas", value: " , ,", wantErr: true},
+		{name: "all namespaces", value: " * ", want: nil},
+		{name: "single namespace", value: "ns1", want: map[string]cache.Config{"ns1": {}}},
+		{
+			name:  "comma list with spaces",
+			value: " ns1 , ns2,ns3 ",
+			want:  map[string]cache.Config{"ns1": {}, "ns2": {}, "ns3": {}},
+		},
+		{name: "duplicates", value: "ns1,ns2,ns1", want: map[string]cache.Config{"ns1": {}, "ns2": {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWatchNamespaces(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWatchNamespaces(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretNamespaces(t *testing.T) {
+	defaultSecret := types.NamespacedName{Name: "creds", Namespace: "system"}
+	tests := []struct {
+		name            string
+		watchNamespaces map[string]cache.Config
+		defaultSecret   types.NamespacedName
+		want            map[string]cache.Config
+	}{
+		{name: "all namespaces", watchNamespaces: nil, defaultSecret: defaultSecret, want: nil},
+		{
+			name:            "no default Secret",
+			watchNamespaces: map[string]cache.Config{"ns1": {}},
+			want:            map[string]cache.Config{"ns1": {}},
+		},
+		{
+			name:            "default Secret",
+			watchNamespaces: map[string]cache.Config{"ns1": {}},
+			defaultSecret:   defaultSecret,
+			want:            map[string]cache.Config{"ns1": {}, "system": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := secretNamespaces(tt.watchNamespaces, tt.defaultSecret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("secretNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Package audit records who triggered what through Discord interactions. Every
+// verified interaction and the final result of every run are written to a
+// Sink as an Entry.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Decision is what vahkane decided to do for an interaction.
+type Decision string
+
+const (
+	// DecisionQueued means a Job or an object was created for the action.
+	DecisionQueued Decision = "queued"
+	// DecisionRefused means the action was not run, such as because it was
+	// already running or rate limited.
+	DecisionRefused Decision = "refused"
+	// DecisionNoMatch means the command matched no action.
+	DecisionNoMatch Decision = "no_match"
+	// DecisionExecuted means an action without a Job was run, such as an
+	// operation, an HTTP call or a reply.
+	DecisionExecuted Decision = "executed"
+	// DecisionFailed means the interaction couldn't be handled because of an
+	// error.
+	DecisionFailed Decision = "failed"
+	// DecisionCancelled means a run was cancelled by the invoker.
+	DecisionCancelled Decision = "cancelled"
+	// DecisionStatus means the built-in status command was answered.
+	DecisionStatus Decision = "status"
+	// DecisionFinished is recorded when a run finishes, with its result.
+	DecisionFinished Decision = "finished"
+)
+
+// Entry is a record of an interaction or of the result of a run.
+type Entry struct {
+	Time               time.Time   `json:"time"`
+	InteractionID      string      `json:"interactionID,omitempty"`
+	DiscordInteraction string      `json:"discordInteraction,omitempty"`
+	GuildID            string      `json:"guildID,omitempty"`
+	ChannelID          string      `json:"channelID,omitempty"`
+	UserID             string      `json:"userID,omitempty"`
+	UserName           string      `json:"userName,omitempty"`
+	Command            interface{} `json:"command,omitempty"`
+	Action             string      `json:"action,omitempty"`
+	Decision           Decision    `json:"decision"`
+	Reason             string      `json:"reason,omitempty"`
+	JobName            string      `json:"jobName,omitempty"`
+	Result             string      `json:"result,omitempty"`
+}
+
+// Sink stores audit entries. Write must be safe for concurrent use and must
+// not block for long because it is called while handling interactions.
+type Sink interface {
+	Write(ctx context.Context, entry *Entry) error
+}
+
+type discard struct{}
+
+func (discard) Write(context.Context, *Entry) error {
+	return nil
+}
+
+// Discard is the Sink that drops every entry.
+var Discard Sink = discard{}
+
+// Run starts the background work of the sink, such as sending the entries
+// queued in HTTPSink, and returns the function that stops it and closes the
+// sink. The returned function blocks until the sink is stopped, so it should
+// be called after the writers of the entries have stopped.
+func Run(ctx context.Context, sink Sink) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	if starter, ok := sink.(interface{ Start(context.Context) error }); ok {
+		go func() {
+			defer close(stopped)
+			if err := starter.Start(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "audit sink stopped")
+			}
+		}()
+	} else {
+		close(stopped)
+	}
+
+	return func() {
+		cancel()
+		<-stopped
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.FromContext(ctx).Error(err, "failed to close the audit sink")
+			}
+		}
+	}
+}
+
+// NewSink returns the Sink described by spec: "" to drop entries, "stdout" to
+// write JSON lines to the standard output, "file:<path>" to append JSON lines
+// to the file, or an http(s) URL to POST each entry as JSON.
+func NewSink(spec string) (Sink, error) {
+	switch {
+	case spec == "":
+		return Discard, nil
+	case spec == "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return newFileSink(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewHTTPSink(spec), nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink: %s", spec)
+	}
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, tc := range []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: ""},
+		{spec: "stdout"},
+		{spec: "file:" + path},
+		{spec: "https://collector.example.com/audit"},
+		{spec: "syslog", wantErr: true},
+		{spec: "file:" + filepath.Join(path, "not-a-directory", "audit.log"), wantErr: true},
+	} {
+		_, err := NewSink(tc.spec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error: %v", tc.spec, err)
+		}
+	}
+
+	sink, err := NewSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decision := range []Decision{DecisionQueued, DecisionFinished} {
+		if err := sink.Write(context.Background(), &Entry{InteractionID: "1", Decision: decision}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("unexpected audit log: %s", data)
+	}
+	var entry Entry
+	if err := json.Unmarshal(lines[1], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.InteractionID != "1" || entry.Decision != DecisionFinished {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	received := make(chan Entry, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var entry Entry
+		if err := json.NewDecoder(req.Body).Decode(&entry); err != nil {
+			t.Error(err)
+		}
+		received <- entry
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = sink.Start(ctx)
+	}()
+
+	if err := sink.Write(ctx, &Entry{InteractionID: "1", Decision: DecisionQueued}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case entry := <-received:
+		if entry.InteractionID != "1" || entry.Decision != DecisionQueued {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the entry was not sent")
+	}
+
+	cancel()
+	<-stopped
+
+	// The entries left in the queue are sent on shutdown.
+	sink = NewHTTPSink(srv.URL)
+	if err := sink.Write(context.Background(), &Entry{InteractionID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case entry := <-received:
+		if entry.InteractionID != "2" {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+	default:
+		t.Error("the queued entry was not sent on shutdown")
+	}
+}
+
+func TestHTTPSinkDrainTimeout(t *testing.T) {
+	// The collector never replies until the test ends.
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	sink := NewHTTPSink(srv.URL)
+	sink.drainTimeout = 100 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(context.Background(), &Entry{InteractionID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := sink.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the drain took too long: %v", elapsed)
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := Run(context.Background(), sink)
+	if err := sink.Write(context.Background(), &Entry{InteractionID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if err := sink.Write(context.Background(), &Entry{InteractionID: "2"}); err == nil {
+		t.Error("the file should be closed")
+	}
+
+	// The entries queued in HTTPSink are sent before Run stops it.
+	received := make(chan Entry, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var entry Entry
+		_ = json.NewDecoder(req.Body).Decode(&entry)
+		received <- entry
+	}))
+	defer srv.Close()
+	httpSink := NewHTTPSink(srv.URL)
+	stop = Run(context.Background(), httpSink)
+	if err := httpSink.Write(context.Background(), &Entry{InteractionID: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	select {
+	case entry := <-received:
+		if entry.InteractionID != "3" {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+	default:
+		t.Error("the queued entry was not sent")
+	}
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	httpSinkQueueSize    = 1024
+	httpSinkTimeout      = 10 * time.Second
+	httpSinkDrainTimeout = 10 * time.Second
+)
+
+var errQueueFull = errors.New("audit queue is full")
+
+// WriterSink writes the entries to the writer as JSON lines.
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// newFileSink returns the WriterSink that appends the entries to the file and
+// closes it on Close.
+func newFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	return &WriterSink{encoder: json.NewEncoder(file), closer: file}, nil
+}
+
+func (s *WriterSink) Write(_ context.Context, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(entry)
+}
+
+// Close closes the file of the sink. The writer given to NewWriterSink is
+// left open.
+func (s *WriterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// HTTPSink POSTs each entry as JSON to a collector. The entries are queued and
+// sent in the background by Start so that the collector doesn't slow down the
+// interactions. Entries are dropped if the queue is full.
+type HTTPSink struct {
+	url          string
+	httpClient   *http.Client
+	queue        chan *Entry
+	drainTimeout time.Duration
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url:          url,
+		httpClient:   &http.Client{Timeout: httpSinkTimeout},
+		queue:        make(chan *Entry, httpSinkQueueSize),
+		drainTimeout: httpSinkDrainTimeout,
+	}
+}
+
+func (s *HTTPSink) Write(_ context.Context, entry *Entry) error {
+	select {
+	case s.queue <- entry:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Start sends the queued entries until ctx is done, and then sends the
+// entries left in the queue. Sending is cut off when the drain timeout passes
+// after ctx is done.
+func (s *HTTPSink) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("audit")
+
+	sendCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(s.drainTimeout, cancel)
+	})
+	defer stop()
+
+	for {
+		var entry *Entry
+		select {
+		case entry = <-s.queue:
+		case <-ctx.Done():
+			select {
+			case entry = <-s.queue:
+			default:
+				return nil
+			}
+		}
+		if sendCtx.Err() != nil {
+			logger.Info("drop audit entries left after the drain timeout", "entries", len(s.queue)+1)
+			return nil
+		}
+		if err := s.send(sendCtx, entry); err != nil {
+			logger.Error(err, "failed to send audit entry", "interaction_id", entry.InteractionID)
+		}
+	}
+}
+
+func (s *HTTPSink) send(ctx context.Context, entry *Entry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", "vahkane")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
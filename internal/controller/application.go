@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrApplicationNotFound = errors.New("discord application not found")
+
+// Application is a Discord application with its credentials resolved.
+type Application struct {
+	// Key identifies the DiscordApplication. It is zero for the default
+	// application.
+	Key types.NamespacedName
+	ID  string
+	// Token is the bot token, which is used to connect to the Gateway.
+	Token string
+	// PublicKeys are the keys accepted when verifying interactions. More
+	// than one key is accepted while the key is being rotated.
+	PublicKeys []ed25519.PublicKey
+	Client     discord.Client
+}
+
+// tokenSetter is implemented by the discord.Clients whose token can be
+// replaced without recreating them.
+type tokenSetter interface {
+	SetToken(token string)
+}
+
+type resolvedApplication struct {
+	secretUID             types.UID
+	secretResourceVersion string
+	app                   *Application
+}
+
+// ApplicationResolver resolves DiscordApplications into Applications. It
+// keeps one Application per application and rebuilds it when the Secret of
+// the application changes, so that a rotated token or public key takes
+// effect without a restart. The Secrets are read from the cache, which is
+// kept up to date by watching them, so resolving the application of an
+// unauthenticated request doesn't reach the API server.
+type ApplicationResolver struct {
+	k8sClient          client.Client
+	defaultApplication *Application
+	defaultSecret      types.NamespacedName
+	newClient          func(applicationID, token string) discord.Client
+
+	mu           sync.Mutex
+	applications map[types.NamespacedName]*resolvedApplication
+}
+
+// NewApplicationResolver creates an ApplicationResolver. The default
+// application is used by DiscordInteractions without ApplicationRef. It is
+// defaultApplication if it is not nil, or is read from defaultSecret if it is
+// not zero. Otherwise, there is no default application.
+func NewApplicationResolver(
+	k8sClient client.Client,
+	defaultApplication *Application,
+	defaultSecret types.NamespacedName,
+	newClient func(applicationID, token string) discord.Client,
+) *ApplicationResolver {
+	return &ApplicationResolver{
+		k8sClient:          k8sClient,
+		defaultApplication: defaultApplication,
+		defaultSecret:      defaultSecret,
+		newClient:          newClient,
+		applications:       map[types.NamespacedName]*resolvedApplication{},
+	}
+}
+
+// +kubebuilder:rbac:groups=vahkane.anqou.net,resources=discordapplications,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Resolve returns the application identified by key. The zero key means the
+// default application.
+func (r *ApplicationResolver) Resolve(
+	ctx context.Context,
+	key types.NamespacedName,
+) (*Application, error) {
+	if key == (types.NamespacedName{}) {
+		if r.defaultApplication != nil {
+			return r.defaultApplication, nil
+		}
+		if r.defaultSecret == (types.NamespacedName{}) {
+			return nil, fmt.Errorf("default application is not configured: %w", ErrApplicationNotFound)
+		}
+		return r.resolveSecret(ctx, key, r.defaultSecret)
+	}
+
+	var da vahkanev1.DiscordApplication
+	if err := r.k8sClient.Get(ctx, key, &da); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%s: %w", key, ErrApplicationNotFound)
+		}
+		return nil, fmt.Errorf("failed to get DiscordApplication: %w", err)
+	}
+
+	return r.resolveSecret(
+		ctx,
+		key,
+		types.NamespacedName{Name: da.Spec.SecretRef.Name, Namespace: key.Namespace},
+	)
+}
+
+func (r *ApplicationResolver) resolveSecret(
+	ctx context.Context,
+	key types.NamespacedName,
+	secretKey types.NamespacedName,
+) (*Application, error) {
+	// The Application is rebuilt only if the cached Secret has changed.
+	var secret corev1.Secret
+	if err := r.k8sClient.Get(ctx, secretKey, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get Secret of Discord application: %s: %w", secretKey, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resolved, ok := r.applications[key]
+	if ok &&
+		resolved.secretUID == secret.GetUID() &&
+		resolved.secretResourceVersion == secret.GetResourceVersion() {
+		return resolved.app, nil
+	}
+
+	var current *Application
+	if ok {
+		current = resolved.app
+	}
+	app, err := r.buildApplication(key, &secret, current)
+	if err != nil {
+		return nil, err
+	}
+	r.applications[key] = &resolvedApplication{
+		secretUID:             secret.GetUID(),
+		secretResourceVersion: secret.GetResourceVersion(),
+		app:                   app,
+	}
+	return app, nil
+}
+
+// ResolveForDiscordInteraction returns the application used by the DiscordInteraction.
+func (r *ApplicationResolver) ResolveForDiscordInteraction(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+) (*Application, error) {
+	return r.Resolve(ctx, ApplicationKey(di))
+}
+
+// buildApplication builds the Application from the Secret. The client of
+// current is reused if the application ID is unchanged.
+func (r *ApplicationResolver) buildApplication(
+	key types.NamespacedName,
+	secret *corev1.Secret,
+	current *Application,
+) (*Application, error) {
+	values := map[string]string{}
+	for _, k := range []string{
+		vahkanev1.SecretKeyApplicationID,
+		vahkanev1.SecretKeyToken,
+		vahkanev1.SecretKeyPublicKey,
+	} {
+		value, ok := secret.Data[k]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in Secret: %s", k, secret.GetName())
+		}
+		values[k] = string(value)
+	}
+
+	publicKeys, err := ParsePublicKeys(values[vahkanev1.SecretKeyPublicKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key in Secret: %s: %w", secret.GetName(), err)
+	}
+
+	id := values[vahkanev1.SecretKeyApplicationID]
+	token := values[vahkanev1.SecretKeyToken]
+
+	var discordClient discord.Client
+	if current != nil && current.ID == id {
+		if setter, ok := current.Client.(tokenSetter); ok {
+			setter.SetToken(token)
+			discordClient = current.Client
+		}
+	}
+	if discordClient == nil {
+		discordClient = r.newClient(id, token)
+	}
+
+	return &Application{
+		Key:        key,
+		ID:         id,
+		Token:      token,
+		PublicKeys: publicKeys,
+		Client:     discordClient,
+	}, nil
+}
+
+// ParsePublicKeys parses the hex-encoded public keys separated by commas or
+// whitespace.
+func ParsePublicKeys(value string) ([]ed25519.PublicKey, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) == 0 {
+		return nil, errors.New("no public key")
+	}
+
+	publicKeys := []ed25519.PublicKey{}
+	for _, field := range fields {
+		publicKey, err := hex.DecodeString(field)
+		if err != nil {
+			return nil, err
+		}
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key size: %d", len(publicKey))
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+// ApplicationKey returns the key of the application used by the
+// DiscordInteraction. It is zero for the default application.
+func ApplicationKey(di *vahkanev1.DiscordInteraction) types.NamespacedName {
+	if di.Spec.ApplicationRef == nil {
+		return types.NamespacedName{}
+	}
+	return types.NamespacedName{Name: di.Spec.ApplicationRef.Name, Namespace: di.GetNamespace()}
+}
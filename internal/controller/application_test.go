@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type tokenClient struct {
+	discord.Client
+	token string
+}
+
+func (c *tokenClient) SetToken(token string) {
+	c.token = token
+}
+
+func TestApplicationResolverRotation(t *testing.T) {
+	ctx := context.Background()
+
+	key1, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "ns"},
+		Data: map[string][]byte{
+			vahkanev1.SecretKeyApplicationID: []byte("app"),
+			vahkanev1.SecretKeyToken:         []byte("token1"),
+			vahkanev1.SecretKeyPublicKey:     []byte(hex.EncodeToString(key1)),
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(secret).Build()
+
+	created := 0
+	resolver := NewApplicationResolver(
+		k8sClient,
+		nil,
+		types.NamespacedName{Name: "creds", Namespace: "ns"},
+		func(applicationID, token string) discord.Client {
+			created++
+			return &tokenClient{token: token}
+		},
+	)
+
+	app, err := resolver.Resolve(ctx, types.NamespacedName{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.PublicKeys) != 1 || app.Client.(*tokenClient).token != "token1" {
+		t.Fatalf("unexpected application: %+v", app)
+	}
+	if again, err := resolver.Resolve(ctx, types.NamespacedName{}); err != nil || again != app {
+		t.Errorf("the application should be reused while the Secret is unchanged: %v", err)
+	}
+
+	// Rotate the token and add a new public key.
+	secret.Data[vahkanev1.SecretKeyToken] = []byte("token2")
+	secret.Data[vahkanev1.SecretKeyPublicKey] = []byte(hex.EncodeToString(key1) + "," + hex.EncodeToString(key2))
+	if err := k8sClient.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err = resolver.Resolve(ctx, types.NamespacedName{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.PublicKeys) != 2 {
+		t.Errorf("unexpected number of public keys: %d", len(app.PublicKeys))
+	}
+	if token := app.Client.(*tokenClient).token; token != "token2" {
+		t.Errorf("token is not rotated: %s", token)
+	}
+	if created != 1 {
+		t.Errorf("client should be reused: created %d times", created)
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	key1, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ParsePublicKeys(hex.EncodeToString(key1) + ",\n" + hex.EncodeToString(key2) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(key1) || !keys[1].Equal(key2) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	for _, value := range []string{"", "zz", "abcd"} {
+		if _, err := ParsePublicKeys(value); err == nil {
+			t.Errorf("ParsePublicKeys should fail: %q", value)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// concurrencyLockTTL is how long the concurrency lock is held at most. A
+	// lock held longer, e.g., by a crashed replica, is taken over. The lock
+	// is held only while the Jobs are counted and one is created or resumed.
+	concurrencyLockTTL = 2 * time.Second
+	// concurrencyLockWait is how long the lock is waited for. It is long
+	// enough to take over a lock left by a crashed holder, and short enough
+	// for the run to be started within the task timeout of the webhook
+	// server, which is 5 seconds by default.
+	concurrencyLockWait = concurrencyLockTTL + time.Second
+
+	concurrencyLockRetryInterval = 100 * time.Millisecond
+)
+
+// concurrencyLockName returns the name of the Lease of the concurrency lock of
+// the DiscordInteraction.
+func concurrencyLockName(diName string) string {
+	return fmt.Sprintf("concurrency-%s", encodeHash(diName))
+}
+
+// lockConcurrency serializes the starts and the resumptions of the Jobs of the
+// DiscordInteraction, so that the Jobs counted against MaxConcurrentJobs don't
+// change until the returned function unlocks it. The webhook servers on
+// different replicas and JobQueueReconciler take the lock, which is a Lease
+// deleted on unlock. holder identifies the taker of the lock.
+func lockConcurrency(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	holder string,
+) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, concurrencyLockWait)
+	defer cancel()
+
+	key := types.NamespacedName{Name: concurrencyLockName(di.GetName()), Namespace: di.GetNamespace()}
+	for {
+		lease, err := tryLockConcurrency(ctx, k8sClient, apiReader, di, key, holder)
+		if err != nil {
+			return nil, err
+		}
+		if lease != nil {
+			return func() {
+				// The lock taken over by others is left to them.
+				rv := lease.GetResourceVersion()
+				if err := k8sClient.Delete(
+					context.WithoutCancel(ctx),
+					lease,
+					client.Preconditions{ResourceVersion: &rv},
+				); err != nil && !k8serrors.IsNotFound(err) && !k8serrors.IsConflict(err) {
+					log.FromContext(ctx).Error(err, "failed to unlock the concurrency lock", "lease", key.Name)
+				}
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to take the concurrency lock: %w", ctx.Err())
+		case <-time.After(concurrencyLockRetryInterval):
+		}
+	}
+}
+
+// tryLockConcurrency takes the concurrency lock if it is free or expired. It
+// returns the Lease of the lock, or nil if another holder has it.
+func tryLockConcurrency(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	key types.NamespacedName,
+	holder string,
+) (*coordinationv1.Lease, error) {
+	var lease coordinationv1.Lease
+	lease.SetName(key.Name)
+	lease.SetNamespace(key.Namespace)
+	now := metav1.NowMicro()
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.AcquireTime = &now
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(concurrencyLockTTL / time.Second))
+	if err := controllerutil.SetOwnerReference(di, &lease, k8sClient.Scheme()); err != nil {
+		return nil, fmt.Errorf("failed to set owner reference: %w", err)
+	}
+	err := k8sClient.Create(ctx, &lease)
+	if err == nil {
+		return &lease, nil
+	}
+	if !k8serrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create Lease: %w", err)
+	}
+
+	if err := apiReader.Get(ctx, key, &lease); err != nil {
+		if k8serrors.IsNotFound(err) {
+			// The lock has just been unlocked.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get Lease: %w", err)
+	}
+	if lease.Spec.AcquireTime != nil && now.Sub(lease.Spec.AcquireTime.Time) < concurrencyLockTTL {
+		return nil, nil
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.AcquireTime = &now
+	if err := k8sClient.Update(ctx, &lease); err != nil {
+		if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to update Lease: %w", err)
+	}
+	return &lease, nil
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func TestLockConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(di).Build()
+	key := types.NamespacedName{Name: concurrencyLockName("di"), Namespace: "ns"}
+
+	unlock, err := lockConcurrency(ctx, k8sClient, k8sClient, di, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is waited for until the context is done.
+	shortCtx, cancel := context.WithTimeout(ctx, 3*concurrencyLockRetryInterval)
+	defer cancel()
+	if _, err := lockConcurrency(shortCtx, k8sClient, k8sClient, di, "run2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("the lock should be held by run1: %v", err)
+	}
+
+	// Unlocking deletes the Lease, and the lock is taken again.
+	unlock()
+	var lease coordinationv1.Lease
+	if err := k8sClient.Get(ctx, key, &lease); !k8serrors.IsNotFound(err) {
+		t.Fatalf("the Lease should be deleted: %v", err)
+	}
+	unlock, err = lockConcurrency(ctx, k8sClient, k8sClient, di, "run2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired lock is taken over, and the previous holder doesn't unlock
+	// it.
+	if err := k8sClient.Get(ctx, key, &lease); err != nil {
+		t.Fatal(err)
+	}
+	lease.Spec.AcquireTime = ptr.To(metav1.NewMicroTime(time.Now().Add(-concurrencyLockTTL)))
+	if err := k8sClient.Update(ctx, &lease); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockConcurrency(ctx, k8sClient, k8sClient, di, "run3"); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if err := k8sClient.Get(ctx, key, &lease); err != nil {
+		t.Fatalf("the Lease taken over should be left: %v", err)
+	}
+	if holder := ptr.Deref(lease.Spec.HolderIdentity, ""); holder != "run3" {
+		t.Errorf("unexpected holder: %s", holder)
+	}
+}
+
+func TestLockConcurrencyTakesOverStaleLock(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(di).Build()
+
+	// The holder has crashed right after taking the lock.
+	if _, err := lockConcurrency(ctx, k8sClient, k8sClient, di, "crashed"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := lockConcurrency(ctx, k8sClient, k8sClient, di, "run"); err != nil {
+		t.Fatalf("the stale lock should be taken over: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < concurrencyLockTTL || elapsed > concurrencyLockWait {
+		t.Errorf("the stale lock should be taken over once it expires: %v", elapsed)
+	}
+}
@@ -9,8 +9,14 @@ import (
 	"errors"
 	"fmt"
 
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -18,42 +24,64 @@ import (
 	"sigs.k8s.io/yaml"
 
 	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
 	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
 )
 
 const (
 	annotKeyCommands            = "vahkane.anqou.net/commands"
+	annotKeyCommandsApplication = "vahkane.anqou.net/commands-application"
 	LabelKeyDiscordGuildID      = "vahkane.anqou.net/discord-guild-id"
 	finalizerDiscordInteraction = "vahkane.anqou.net/discord-interaction"
+
+	eventReasonCommandsRegistered        = "CommandsRegistered"
+	eventReasonCommandRegistrationFailed = "CommandRegistrationFailed"
+	eventReasonRunCancelled              = "RunCancelled"
+	eventReasonInvalidActions            = "InvalidActions"
 )
 
 var errRequeue = errors.New("requeue")
 
 // DiscordInteractionReconciler reconciles a DiscordInteraction object
 type DiscordInteractionReconciler struct {
-	Client        client.Client
-	Scheme        *runtime.Scheme
-	namespace     string
-	discordClient discord.Client
+	Client       client.Client
+	Scheme       *runtime.Scheme
+	apiReader    client.Reader
+	applications *ApplicationResolver
+	recorder     record.EventRecorder
+	audit        audit.Sink
 }
 
+// NewDiscordInteractionReconciler creates a DiscordInteractionReconciler. The
+// runs cancelled by the deletion of the DiscordInteraction are written to
+// auditSink if it is not nil.
 func NewDiscordInteractionReconciler(
 	client client.Client,
+	apiReader client.Reader,
 	scheme *runtime.Scheme,
-	namespace string,
-	discordClient discord.Client,
+	applications *ApplicationResolver,
+	recorder record.EventRecorder,
+	auditSink audit.Sink,
 ) *DiscordInteractionReconciler {
+	if auditSink == nil {
+		auditSink = audit.Discard
+	}
 	return &DiscordInteractionReconciler{
-		Client:        client,
-		Scheme:        scheme,
-		namespace:     namespace,
-		discordClient: discordClient,
+		Client:       client,
+		Scheme:       scheme,
+		apiReader:    apiReader,
+		applications: applications,
+		recorder:     recorder,
+		audit:        auditSink,
 	}
 }
 
 // +kubebuilder:rbac:groups=vahkane.anqou.net,resources=discordinteractions,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=vahkane.anqou.net,resources=discordinteractions/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=vahkane.anqou.net,resources=discordinteractions/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -101,6 +129,10 @@ func (r *DiscordInteractionReconciler) doReconcile(
 		return err
 	}
 
+	if err := r.updateActionsCondition(ctx, di); err != nil {
+		return fmt.Errorf("failed to update the condition of the actions: %w", err)
+	}
+
 	var guildIDUpdated, commandsUpdated bool
 
 	guildID, ok := di.GetLabels()[LabelKeyDiscordGuildID]
@@ -108,12 +140,22 @@ func (r *DiscordInteractionReconciler) doReconcile(
 		guildIDUpdated = true
 	}
 
+	commands, err := guildCommands(di)
+	if err != nil {
+		return fmt.Errorf("failed to build guild commands: %w", err)
+	}
+
 	// Check whether commands are updated or not
 	var commandsConcatenated bytes.Buffer
-	for _, command := range di.Spec.Commands {
+	for _, command := range commands {
 		commandsConcatenated.WriteString(command)
 		commandsConcatenated.WriteByte(0)
 	}
+	// Re-register the commands when the DiscordInteraction switches applications.
+	if di.Spec.ApplicationRef != nil {
+		commandsConcatenated.WriteString(di.Spec.ApplicationRef.Name)
+		commandsConcatenated.WriteByte(0)
+	}
 	currentCommandsHashRaw := sha256.Sum224(commandsConcatenated.Bytes())
 	currentCommandsHash := hex.EncodeToString(currentCommandsHashRaw[:])
 	annotCommandsHash, ok := di.GetAnnotations()[annotKeyCommands]
@@ -121,6 +163,31 @@ func (r *DiscordInteractionReconciler) doReconcile(
 		commandsUpdated = true
 	}
 
+	// The name of the DiscordApplication is empty for the default one.
+	var applicationName string
+	if di.Spec.ApplicationRef != nil {
+		applicationName = di.Spec.ApplicationRef.Name
+	}
+
+	// Register the commands before recording their hash so that a failed
+	// registration is retried.
+	if commandsUpdated {
+		if previous, ok := di.GetAnnotations()[annotKeyCommandsApplication]; ok && previous != applicationName {
+			if err := r.unregisterPreviousGuildCommands(ctx, di, previous); err != nil {
+				return err
+			}
+		}
+
+		logger.Info("register Discord guild commands", "guild_id", di.Spec.GuildID)
+		if err := r.registerGuildCommands(ctx, di, commands); err != nil {
+			r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonCommandRegistrationFailed,
+				"Failed to register guild commands: %v", err)
+			return err
+		}
+		r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonCommandsRegistered,
+			"Registered %d guild commands", len(commands))
+	}
+
 	if guildIDUpdated || commandsUpdated {
 		labels := di.GetLabels()
 		if labels == nil {
@@ -134,6 +201,7 @@ func (r *DiscordInteractionReconciler) doReconcile(
 			annots = map[string]string{}
 		}
 		annots[annotKeyCommands] = currentCommandsHash
+		annots[annotKeyCommandsApplication] = applicationName
 		di.SetAnnotations(annots)
 
 		if err := r.Client.Update(ctx, di); err != nil {
@@ -141,26 +209,96 @@ func (r *DiscordInteractionReconciler) doReconcile(
 		}
 	}
 
-	if commandsUpdated {
-		logger.Info("register Discord guild commands", "guild_id", di.Spec.GuildID)
-		if err := deleteAllGuildCommands(ctx, r.discordClient, di.Spec.GuildID); err != nil {
-			return err
+	return nil
+}
+
+// updateActionsCondition sets the ActionsValid condition of the
+// DiscordInteraction. The invalid actions are still registered so that their
+// invokers are told of the failure.
+func (r *DiscordInteractionReconciler) updateActionsCondition(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+) error {
+	condition := metav1.Condition{
+		Type:               vahkanev1.DiscordInteractionConditionActionsValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonValid,
+		ObservedGeneration: di.GetGeneration(),
+	}
+	if err := validateActions(di); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = conditionReasonInvalidActions
+		condition.Message = err.Error()
+	}
+	if !meta.SetStatusCondition(&di.Status.Conditions, condition) {
+		return nil
+	}
+	if condition.Status == metav1.ConditionFalse {
+		r.recorder.Event(di, corev1.EventTypeWarning, eventReasonInvalidActions, condition.Message)
+	}
+	return r.Client.Status().Update(ctx, di)
+}
+
+// unregisterPreviousGuildCommands deletes the guild commands registered with
+// the application that the DiscordInteraction used before switching
+// applications. Nothing is deleted if the application no longer exists,
+// because its credentials are gone.
+func (r *DiscordInteractionReconciler) unregisterPreviousGuildCommands(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	applicationName string,
+) error {
+	logger := log.FromContext(ctx)
+
+	var key types.NamespacedName
+	if applicationName != "" {
+		key = types.NamespacedName{Namespace: di.GetNamespace(), Name: applicationName}
+	}
+	app, err := r.applications.Resolve(ctx, key)
+	if errors.Is(err, ErrApplicationNotFound) {
+		logger.Info("skip unregistering Discord guild commands of the previous application because it is not found",
+			"guild_id", di.Spec.GuildID, "application", applicationName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve the previous Discord application: %w", err)
+	}
+
+	logger.Info("unregister Discord guild commands of the previous application",
+		"guild_id", di.Spec.GuildID, "application", applicationName)
+	if err := deleteAllGuildCommands(ctx, app.Client, di.Spec.GuildID); err != nil {
+		return fmt.Errorf("failed to delete guild commands of the previous application: %w", err)
+	}
+	return nil
+}
+
+func (r *DiscordInteractionReconciler) registerGuildCommands(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	commands []string,
+) error {
+	app, err := r.applications.ResolveForDiscordInteraction(ctx, di)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Discord application: %w", err)
+	}
+	guildID := di.Spec.GuildID
+
+	if err := deleteAllGuildCommands(ctx, app.Client, guildID); err != nil {
+		return err
+	}
+	for _, command := range commands {
+		json, err := convertYAMLToJSON(command)
+		if err != nil {
+			return fmt.Errorf("failed to convert guild command YAML to JSON: %w", err)
 		}
-		for _, command := range di.Spec.Commands {
-			json, err := convertYAMLToJSON(command)
-			if err != nil {
-				return fmt.Errorf("failed to convert guild command YAML to JSON: %w", err)
-			}
-			if err := r.discordClient.RegisterGuildCommand(
-				ctx,
-				di.Spec.GuildID,
-				json,
-			); err != nil {
-				return fmt.Errorf("failed to register Discord guild commands: %w", err)
-			}
+		if err := app.Client.RegisterGuildCommand(
+			ctx,
+			guildID,
+			json,
+		); err != nil {
+			return fmt.Errorf("failed to register Discord guild commands: %w", err)
 		}
 	}
-
 	return nil
 }
 
@@ -171,8 +309,23 @@ func (r *DiscordInteractionReconciler) handleFinalizer(
 	logger := log.FromContext(ctx)
 
 	if !di.GetDeletionTimestamp().IsZero() {
+		app, err := r.applications.ResolveForDiscordInteraction(ctx, di)
+		if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+			return fmt.Errorf("failed to resolve Discord application: %w", err)
+		}
+
+		// The objects of the runs are garbage-collected with the
+		// DiscordInteraction, but their invokers should know why.
+		if err := r.cancelRuns(ctx, di, app); err != nil {
+			return fmt.Errorf("failed to cancel runs: %w", err)
+		}
+
 		logger.Info("unregister Discord guild commands", "guild_id", di.Spec.GuildID)
-		if err := deleteAllGuildCommands(ctx, r.discordClient, di.Spec.GuildID); err != nil {
+		if app == nil {
+			// The commands can't be deleted without the application's credentials.
+			logger.Info("skip unregistering Discord guild commands because the application is not found",
+				"guild_id", di.Spec.GuildID)
+		} else if err := deleteAllGuildCommands(ctx, app.Client, di.Spec.GuildID); err != nil {
 			return fmt.Errorf("failed to delete guild commands: %w", err)
 		}
 
@@ -194,6 +347,141 @@ func (r *DiscordInteractionReconciler) handleFinalizer(
 	return nil
 }
 
+// cancelRuns cancels the runs of the DiscordInteraction in progress and tells
+// their invokers. app is nil if the application is not found, in which case
+// the invokers are not told.
+func (r *DiscordInteractionReconciler) cancelRuns(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	app *Application,
+) error {
+	running, queued, err := ListDiscordInteractionJobs(ctx, r.Client, di)
+	if err != nil {
+		return err
+	}
+	var objs []client.Object
+	for _, jobs := range [][]batchv1.Job{running, queued} {
+		for i := range jobs {
+			objs = append(objs, &jobs[i])
+		}
+	}
+
+	for i := range di.Spec.Actions {
+		action := &di.Spec.Actions[i]
+		resource := action.ActionInline.Resource
+		if resource == nil {
+			continue
+		}
+		items, err := ListRunResources(
+			ctx,
+			r.Client,
+			resource,
+			di.GetNamespace(),
+			map[string]string{LabelKeyJobGroup: JobGroupName(di.GetName(), action)},
+		)
+		if err != nil {
+			// The objects can't exist if their kind no longer exists.
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		for j := range items {
+			item := &items[j]
+			if finished, _ := ResourceRunResult(item, &resource.Completion); finished ||
+				!item.GetDeletionTimestamp().IsZero() {
+				continue
+			}
+			objs = append(objs, item)
+		}
+	}
+
+	for _, obj := range objs {
+		if err := r.cancelRun(ctx, di, app, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelRun deletes the object of the run, records that the run is cancelled
+// and tells the invoker. Failing to tell the invoker is not fatal, so that the
+// DiscordInteraction can be deleted even if Discord is unavailable.
+func (r *DiscordInteractionReconciler) cancelRun(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	app *Application,
+	obj client.Object,
+) error {
+	logger := log.FromContext(ctx)
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		// The run has been cancelled by the previous attempt.
+		return nil
+	}
+	propagationPolicy := metav1.DeletePropagationBackground
+	if err := r.Client.Delete(ctx, obj, &client.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			// The run has finished and been reported.
+			return nil
+		}
+		return fmt.Errorf("failed to delete %s: %w", obj.GetName(), err)
+	}
+	if err := UpdateInteractionRunPhase(ctx, r.Client, r.apiReader, obj, vahkanev1.InteractionRunPhaseCancelled); err != nil {
+		return fmt.Errorf("failed to update InteractionRun: %w", err)
+	}
+	metrics.JobsFinishedTotal.WithLabelValues(
+		di.GetName(), obj.GetAnnotations()[AnnotKeyAction], string(vahkanev1.RunResultCancelled)).Inc()
+	writeRunAudit(ctx, r.audit, obj, vahkanev1.RunResultCancelled)
+	logger.Info("cancelled run because the DiscordInteraction is deleted", "job", obj.GetName())
+	r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonRunCancelled,
+		"Cancelled %s because the DiscordInteraction is deleted", obj.GetName())
+
+	if app == nil {
+		return nil
+	}
+	var action *vahkanev1.DiscordInteractionAction
+	for i := range di.Spec.Actions {
+		if di.Spec.Actions[i].Name == obj.GetAnnotations()[AnnotKeyAction] {
+			action = &di.Spec.Actions[i]
+			break
+		}
+	}
+	if err := sendRunMessage(
+		ctx,
+		app.Client,
+		obj,
+		":stop_sign: cancelled because the DiscordInteraction was deleted",
+		IsResultEphemeral(action, vahkanev1.RunResultCancelled),
+	); err != nil {
+		logger.Error(err, "failed to send followup messages")
+		r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonFollowupFailed,
+			"Failed to send the cancellation of %s to Discord: %v", obj.GetName(), err)
+	}
+	return nil
+}
+
+// guildCommands returns the commands to be registered for the
+// DiscordInteraction, including the built-in ones.
+func guildCommands(di *vahkanev1.DiscordInteraction) ([]string, error) {
+	commands := append([]string{}, di.Spec.Commands...)
+	if di.Spec.StatusCommand != nil {
+		// cf. https://discord.com/developers/docs/interactions/application-commands#application-command-object
+		command, err := json.Marshal(map[string]interface{}{
+			"name":        di.Spec.StatusCommand.GetName(),
+			"description": "List the runs in progress",
+			"type":        1, // CHAT_INPUT
+		})
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, string(command))
+	}
+	return commands, nil
+}
+
 func convertYAMLToJSON(src string) (string, error) {
 	var v interface{}
 	if err := yaml.Unmarshal([]byte(src), &v); err != nil {
@@ -14,6 +14,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
@@ -48,7 +49,7 @@ var _ = Describe("DiscordInteraction Controller", func() {
 	Context("When reconciling a resource", func() {
 		var mockCtrl *gomock.Controller
 		var discordClient *discord.MockClient
-		var ns, nsController string
+		var ns string
 		var reconciler *DiscordInteractionReconciler
 
 		BeforeEach(func(ctx SpecContext) {
@@ -67,14 +68,14 @@ var _ = Describe("DiscordInteraction Controller", func() {
 			)
 			Expect(err).NotTo(HaveOccurred())
 
-			nsController = gensym("ns")
-			err = k8sClient.Create(
-				ctx,
-				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: nsController}},
+			reconciler = NewDiscordInteractionReconciler(
+				k8sClient,
+				k8sClient,
+				scheme.Scheme,
+				NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, discord.NewRealClient),
+				&record.FakeRecorder{},
+				nil,
 			)
-			Expect(err).NotTo(HaveOccurred())
-
-			reconciler = NewDiscordInteractionReconciler(k8sClient, scheme.Scheme, nsController, discordClient)
 		})
 
 		It("should successfully reconcile the resource", func(ctx SpecContext) {
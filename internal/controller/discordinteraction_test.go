@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// guildCommandsClient has one registered guild command per application and
+// records the commands deleted and registered through it.
+type guildCommandsClient struct {
+	discord.Client
+	applicationID string
+	deleted       *[]string
+	registered    *[]string
+}
+
+func (c *guildCommandsClient) GetGuildCommands(context.Context, string) ([]map[string]interface{}, error) {
+	return []map[string]interface{}{{"id": c.applicationID + "-command"}}, nil
+}
+
+func (c *guildCommandsClient) DeleteGuildCommand(_ context.Context, _, commandID string) error {
+	*c.deleted = append(*c.deleted, commandID)
+	return nil
+}
+
+func (c *guildCommandsClient) RegisterGuildCommand(context.Context, string, string) error {
+	*c.registered = append(*c.registered, c.applicationID)
+	return nil
+}
+
+func TestReconcileUnregistersPreviousApplication(t *testing.T) {
+	ctx := context.Background()
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := []client.Object{}
+	for _, name := range []string{"old", "new"} {
+		objs = append(objs,
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: name + "-creds", Namespace: "ns"},
+				Data: map[string][]byte{
+					vahkanev1.SecretKeyApplicationID: []byte(name),
+					vahkanev1.SecretKeyToken:         []byte("token"),
+					vahkanev1.SecretKeyPublicKey:     []byte(hex.EncodeToString(publicKey)),
+				},
+			},
+			&vahkanev1.DiscordApplication{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+				Spec: vahkanev1.DiscordApplicationSpec{
+					SecretRef: corev1.LocalObjectReference{Name: name + "-creds"},
+				},
+			},
+		)
+	}
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "di",
+			Namespace:   "ns",
+			Finalizers:  []string{finalizerDiscordInteraction},
+			Annotations: map[string]string{annotKeyCommands: "stale", annotKeyCommandsApplication: "old"},
+		},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			GuildID:        "guild",
+			Commands:       []string{`{"name": "deploy"}`},
+			ApplicationRef: &corev1.LocalObjectReference{Name: "new"},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(append(objs, di)...).
+		WithStatusSubresource(&vahkanev1.DiscordInteraction{}).
+		Build()
+
+	var deleted, registered []string
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewDiscordInteractionReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, nil, types.NamespacedName{}, func(applicationID, _ string) discord.Client {
+			return &guildCommandsClient{applicationID: applicationID, deleted: &deleted, registered: &registered}
+		}),
+		recorder,
+		nil,
+	)
+	if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(di)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(deleted, []string{"old-command", "new-command"}) {
+		t.Errorf("unexpected deleted commands: %v", deleted)
+	}
+	if !slices.Equal(registered, []string{"new"}) {
+		t.Errorf("unexpected registered commands: %v", registered)
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(di), di); err != nil {
+		t.Fatal(err)
+	}
+	if di.Annotations[annotKeyCommandsApplication] != "new" {
+		t.Errorf("unexpected annotations: %v", di.Annotations)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonCommandsRegistered)
+}
+
+// noCommandsClient has no guild commands and records the follow-up messages.
+type noCommandsClient struct {
+	followupClient
+}
+
+func (c *noCommandsClient) GetGuildCommands(context.Context, string) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func TestHandleFinalizerCancelsRuns(t *testing.T) {
+	ctx := context.Background()
+
+	now := metav1.Now()
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "di",
+			Namespace:         "ns",
+			UID:               "di-uid",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{finalizerDiscordInteraction},
+		},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{Name: "build"}},
+		},
+	}
+	newJob := func(name string, conditions ...batchv1.JobCondition) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns",
+				Labels:    map[string]string{LabelKeyJob: "true"},
+				Annotations: map[string]string{
+					AnnotKeyDiscordInteraction:      "di",
+					AnnotKeyAction:                  "build",
+					AnnotKeyDiscordInteractionToken: "token-" + name,
+					AnnotKeyInteractionRun:          name,
+				},
+			},
+			Status: batchv1.JobStatus{Conditions: conditions},
+		}
+	}
+	run := &vahkanev1.InteractionRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "ns"},
+		Status:     vahkanev1.InteractionRunStatus{Phase: vahkanev1.InteractionRunPhaseRunning},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(
+			di,
+			newJob("running"),
+			newJob("finished", batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}),
+			run,
+		).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+
+	discordClient := &noCommandsClient{followupClient{messages: map[string]string{}}}
+	var auditLog bytes.Buffer
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewDiscordInteractionReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, nil),
+		recorder,
+		audit.NewWriterSink(&auditLog),
+	)
+	cancelled := metrics.JobsFinishedTotal.WithLabelValues("di", "build", string(vahkanev1.RunResultCancelled))
+	before := testutil.ToFloat64(cancelled)
+	if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(di)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "running", Namespace: "ns"}, &job); !k8serrors.IsNotFound(err) {
+		t.Errorf("the running Job should be deleted: %v", err)
+	}
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "finished", Namespace: "ns"}, &job); err != nil {
+		t.Errorf("the finished Job should be left: %v", err)
+	}
+	if msg := discordClient.messages["token-running"]; !strings.Contains(msg, "cancelled") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if _, ok := discordClient.messages["token-finished"]; ok {
+		t.Error("the invoker of the finished Job should not be told")
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(run), run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Status.Phase != vahkanev1.InteractionRunPhaseCancelled {
+		t.Errorf("unexpected status: %+v", run.Status)
+	}
+
+	var entry audit.Entry
+	if err := json.Unmarshal(auditLog.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.JobName != "running" || entry.Result != string(vahkanev1.RunResultCancelled) {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+	if got := testutil.ToFloat64(cancelled) - before; got != 1 {
+		t.Errorf("the cancelled run should be counted once: %v", got)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonRunCancelled)
+}
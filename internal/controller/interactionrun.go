@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	eventReasonRunStarted = "RunStarted"
+	eventReasonRunFailed  = "RunFailed"
+)
+
+// defaultInteractionRunTTL is how long finished InteractionRuns are kept if
+// their action has no retention.
+const defaultInteractionRunTTL = 24 * time.Hour
+
+// InteractionRunReconciler starts the runs of the InteractionRuns created by
+// hand. The InteractionRuns created for interactions are only records, and
+// their status is updated by the reconcilers of their Jobs. Finished
+// InteractionRuns lose their interaction tokens and are pruned by the
+// retention of their actions.
+type InteractionRunReconciler struct {
+	Client       client.Client
+	Scheme       *runtime.Scheme
+	apiReader    client.Reader
+	applications *ApplicationResolver
+	recorder     record.EventRecorder
+}
+
+func NewInteractionRunReconciler(
+	client client.Client,
+	apiReader client.Reader,
+	scheme *runtime.Scheme,
+	applications *ApplicationResolver,
+	recorder record.EventRecorder,
+) *InteractionRunReconciler {
+	return &InteractionRunReconciler{
+		Client:       client,
+		Scheme:       scheme,
+		apiReader:    apiReader,
+		applications: applications,
+		recorder:     recorder,
+	}
+}
+
+// +kubebuilder:rbac:groups=vahkane.anqou.net,resources=interactionruns,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=vahkane.anqou.net,resources=interactionruns/status,verbs=get;update;patch
+
+func (r *InteractionRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var run vahkanev1.InteractionRun
+	if err := r.Client.Get(ctx, req.NamespacedName, &run); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !run.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+	if IsInteractionRunFinished(run.Status.Phase) {
+		return r.retainRun(ctx, &run)
+	}
+	if run.Spec.InteractionID != "" || run.Status.Phase != "" {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.startRun(ctx, &run); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *InteractionRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&vahkanev1.InteractionRun{}).
+		Named("interactionrun").
+		Complete(r)
+}
+
+func (r *InteractionRunReconciler) startRun(ctx context.Context, run *vahkanev1.InteractionRun) error {
+	logger := log.FromContext(ctx)
+
+	var di vahkanev1.DiscordInteraction
+	if err := r.Client.Get(
+		ctx,
+		types.NamespacedName{Name: run.Spec.DiscordInteractionRef.Name, Namespace: run.GetNamespace()},
+		&di,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return r.failRun(ctx, nil, run, "the DiscordInteraction is not found")
+		}
+		return err
+	}
+
+	var action *vahkanev1.DiscordInteractionAction
+	for i := range di.Spec.Actions {
+		if di.Spec.Actions[i].Name == run.Spec.Action {
+			action = &di.Spec.Actions[i]
+			break
+		}
+	}
+	if action == nil {
+		return r.failRun(ctx, &di, run, fmt.Sprintf("the action %s is not found", run.Spec.Action))
+	}
+	if inline := &action.ActionInline; inline.Operation != nil || inline.HTTP != nil || inline.Reply != nil {
+		return r.failRun(ctx, &di, run, fmt.Sprintf("the action %s can't be run by an InteractionRun", action.Name))
+	}
+
+	spec := make(map[string]interface{}, len(run.Spec.Options))
+	for name, value := range run.Spec.Options {
+		spec[name] = value
+	}
+	options, err := ValidateParameters(action.Parameters, spec)
+	if err != nil {
+		return r.failRun(ctx, &di, run, err.Error())
+	}
+
+	if metav1.GetControllerOf(run) == nil {
+		if err := controllerutil.SetControllerReference(&di, run, r.Scheme); err != nil {
+			return fmt.Errorf("failed to set owner reference: %w", err)
+		}
+		if err := r.Client.Update(ctx, run); err != nil {
+			return fmt.Errorf("failed to update InteractionRun: %w", err)
+		}
+	}
+
+	// The UID identifies the run like the ID of an interaction, so the Job
+	// is created only once even if the status fails to be updated.
+	jobName, queued, err := StartRun(ctx, r.Client, r.apiReader, &di, action, &RunInvocation{
+		InteractionID: string(run.GetUID()),
+		ChannelID:     run.Spec.ChannelID,
+		UserID:        run.Spec.UserID,
+		UserName:      run.Spec.UserName,
+		Options:       OptionStrings(options),
+		RunName:       run.GetName(),
+	})
+	switch {
+	case errors.Is(err, ErrDuplicateInteraction):
+		// The run was started by the previous attempt.
+	case errors.Is(err, ErrAlreadyRunning), errors.Is(err, ErrTooManyJobs):
+		return r.failRun(ctx, &di, run, fmt.Sprintf("refused to run the action %s: %v", action.Name, err))
+	case err != nil:
+		return fmt.Errorf("failed to start the run: %w", err)
+	}
+
+	now := metav1.Now()
+	run.Status.JobName = jobName
+	run.Status.StartTime = &now
+	run.Status.Phase = vahkanev1.InteractionRunPhaseRunning
+	if queued {
+		run.Status.Phase = vahkanev1.InteractionRunPhaseQueued
+	}
+	if err := r.Client.Status().Update(ctx, run); err != nil {
+		return fmt.Errorf("failed to update the status of InteractionRun: %w", err)
+	}
+	logger.Info("started run", "job", jobName)
+	r.recorder.Eventf(run, corev1.EventTypeNormal, eventReasonRunStarted,
+		"Created Job %s for action %s", jobName, action.Name)
+	return nil
+}
+
+// retainRun drops the interaction token of the finished run, which is no
+// longer needed once the result is reported, and prunes the finished runs of
+// the action. The runs are kept for the TTL and within the history limits of
+// the retention of the action, or for defaultInteractionRunTTL if the action
+// has no retention.
+func (r *InteractionRunReconciler) retainRun(
+	ctx context.Context,
+	run *vahkanev1.InteractionRun,
+) (ctrl.Result, error) {
+	if annots := run.GetAnnotations(); annots[AnnotKeyDiscordInteractionToken] != "" {
+		delete(annots, AnnotKeyDiscordInteractionToken)
+		run.SetAnnotations(annots)
+		if err := r.Client.Update(ctx, run); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to drop the interaction token: %w", err)
+		}
+	}
+
+	retention := &vahkanev1.DiscordInteractionActionRetention{}
+	var di vahkanev1.DiscordInteraction
+	if err := r.Client.Get(
+		ctx,
+		types.NamespacedName{Name: run.Spec.DiscordInteractionRef.Name, Namespace: run.GetNamespace()},
+		&di,
+	); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range di.Spec.Actions {
+		if di.Spec.Actions[i].Name == run.Spec.Action && di.Spec.Actions[i].Retention != nil {
+			retention = di.Spec.Actions[i].Retention
+		}
+	}
+
+	ttl := time.Duration(-1)
+	switch {
+	case retention.TTLSecondsAfterFinished != nil:
+		ttl = time.Duration(*retention.TTLSecondsAfterFinished) * time.Second
+	case retention.SuccessfulJobsHistoryLimit == nil && retention.FailedJobsHistoryLimit == nil:
+		ttl = defaultInteractionRunTTL
+	}
+	if ttl >= 0 && run.Status.CompletionTime != nil {
+		if left := time.Until(run.Status.CompletionTime.Add(ttl)); left > 0 {
+			if err := r.pruneRuns(ctx, run, retention); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: left}, nil
+		}
+		if err := r.Client.Delete(ctx, run); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete InteractionRun: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, r.pruneRuns(ctx, run, retention)
+}
+
+// pruneRuns deletes the finished runs of the action of run that exceed the
+// history limits of the retention. The cancelled runs count as failed ones.
+func (r *InteractionRunReconciler) pruneRuns(
+	ctx context.Context,
+	run *vahkanev1.InteractionRun,
+	retention *vahkanev1.DiscordInteractionActionRetention,
+) error {
+	if retention.SuccessfulJobsHistoryLimit == nil && retention.FailedJobsHistoryLimit == nil {
+		return nil
+	}
+
+	var runList vahkanev1.InteractionRunList
+	if err := r.Client.List(ctx, &runList, client.InNamespace(run.GetNamespace())); err != nil {
+		return fmt.Errorf("failed to list InteractionRuns: %w", err)
+	}
+	var succeeded, failed []*vahkanev1.InteractionRun
+	for i := range runList.Items {
+		item := &runList.Items[i]
+		if item.Spec.DiscordInteractionRef.Name != run.Spec.DiscordInteractionRef.Name ||
+			item.Spec.Action != run.Spec.Action || !IsInteractionRunFinished(item.Status.Phase) {
+			continue
+		}
+		if item.Status.Phase == vahkanev1.InteractionRunPhaseSucceeded {
+			succeeded = append(succeeded, item)
+		} else {
+			failed = append(failed, item)
+		}
+	}
+
+	for _, e := range []struct {
+		runs  []*vahkanev1.InteractionRun
+		limit *int32
+	}{
+		{runs: succeeded, limit: retention.SuccessfulJobsHistoryLimit},
+		{runs: failed, limit: retention.FailedJobsHistoryLimit},
+	} {
+		if e.limit == nil || int32(len(e.runs)) <= *e.limit {
+			continue
+		}
+		sort.Slice(e.runs, func(i, j int) bool {
+			ti, tj := e.runs[i].GetCreationTimestamp(), e.runs[j].GetCreationTimestamp()
+			if ti.Equal(&tj) {
+				return e.runs[i].GetName() > e.runs[j].GetName()
+			}
+			return tj.Before(&ti)
+		})
+		for _, item := range e.runs[*e.limit:] {
+			if err := r.Client.Delete(ctx, item); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("failed to delete InteractionRun: %s: %w", item.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// failRun marks the run as failed with the message, and posts the message to
+// the report channel of the run. di is nil if it is not found.
+func (r *InteractionRunReconciler) failRun(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	run *vahkanev1.InteractionRun,
+	msg string,
+) error {
+	logger := log.FromContext(ctx)
+
+	now := metav1.Now()
+	run.Status.Phase = vahkanev1.InteractionRunPhaseFailed
+	run.Status.Message = msg
+	run.Status.CompletionTime = &now
+	if err := r.Client.Status().Update(ctx, run); err != nil {
+		return fmt.Errorf("failed to update the status of InteractionRun: %w", err)
+	}
+	r.recorder.Eventf(run, corev1.EventTypeWarning, eventReasonRunFailed, "Failed to start the run: %s", msg)
+
+	if di == nil || run.Spec.ChannelID == "" {
+		return nil
+	}
+	app, err := r.applications.Resolve(ctx, ApplicationKey(di))
+	if err == nil {
+		err = app.Client.SendChannelMessage(ctx, run.Spec.ChannelID,
+			fmt.Sprintf("`%s` (%s): :x: %s", run.Spec.Action, run.GetName(), msg))
+	}
+	if err != nil {
+		logger.Error(err, "failed to post the failure of the run")
+		r.recorder.Eventf(run, corev1.EventTypeWarning, eventReasonFollowupFailed,
+			"Failed to post the failure to Discord: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+type channelClient struct {
+	discord.Client
+	messages map[string]string
+}
+
+func (c *channelClient) SendChannelMessage(_ context.Context, channelID, message string) error {
+	c.messages[channelID] = message
+	return nil
+}
+
+func TestInteractionRunReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{
+				{
+					Name: "build",
+					ActionInline: vahkanev1.DiscordInteractionActionInline{
+						JobTemplate: &batchv1.JobTemplateSpec{},
+					},
+				},
+				{
+					Name: "hello",
+					ActionInline: vahkanev1.DiscordInteractionActionInline{
+						Reply: &vahkanev1.DiscordInteractionActionReply{Content: "hello"},
+					},
+				},
+			},
+		},
+	}
+	newRun := func(name, action string) *vahkanev1.InteractionRun {
+		return &vahkanev1.InteractionRun{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(name + "-uid")},
+			Spec: vahkanev1.InteractionRunSpec{
+				DiscordInteractionRef: corev1.LocalObjectReference{Name: "di"},
+				Action:                action,
+				ChannelID:             "channel-" + name,
+			},
+		}
+	}
+	recorded := &vahkanev1.InteractionRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "recorded", Namespace: "ns"},
+		Spec: vahkanev1.InteractionRunSpec{
+			DiscordInteractionRef: corev1.LocalObjectReference{Name: "di"},
+			Action:                "build",
+			InteractionID:         "1234",
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, newRun("manual", "build"), newRun("reply", "hello"), recorded).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+
+	discordClient := &channelClient{messages: map[string]string{}}
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewInteractionRunReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, nil),
+		recorder,
+	)
+	reconcile := func(name string) *vahkanev1.InteractionRun {
+		t.Helper()
+		key := types.NamespacedName{Name: name, Namespace: "ns"}
+		if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+		var run vahkanev1.InteractionRun
+		if err := k8sClient.Get(ctx, key, &run); err != nil {
+			t.Fatal(err)
+		}
+		return &run
+	}
+
+	// A run created by hand triggers the action.
+	run := reconcile("manual")
+	if run.Status.Phase != vahkanev1.InteractionRunPhaseRunning || run.Status.JobName == "" {
+		t.Fatalf("unexpected status: %+v", run.Status)
+	}
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: run.Status.JobName, Namespace: "ns"}, &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Annotations[AnnotKeyInteractionRun] != "manual" || job.Annotations[AnnotKeyReportChannelID] != "channel-manual" {
+		t.Errorf("unexpected annotations: %v", job.Annotations)
+	}
+	if owner := metav1.GetControllerOf(run); owner == nil || owner.UID != di.UID {
+		t.Errorf("unexpected owner: %v", owner)
+	}
+
+	// Actions without Jobs can't be run by hand.
+	run = reconcile("reply")
+	if run.Status.Phase != vahkanev1.InteractionRunPhaseFailed {
+		t.Errorf("unexpected status: %+v", run.Status)
+	}
+	if msg := discordClient.messages["channel-reply"]; !strings.Contains(msg, "can't be run") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	// The records of interactions are left as they are.
+	if run := reconcile("recorded"); run.Status.Phase != "" {
+		t.Errorf("unexpected status: %+v", run.Status)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonRunStarted, eventReasonRunFailed)
+}
+
+func TestInteractionRunRetention(t *testing.T) {
+	ctx := context.Background()
+
+	limit := int32(1)
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{
+				{
+					Name:      "build",
+					Retention: &vahkanev1.DiscordInteractionActionRetention{SuccessfulJobsHistoryLimit: &limit},
+				},
+				{Name: "deploy"},
+			},
+		},
+	}
+	now := time.Now()
+	newRun := func(name, action string, age time.Duration, phase vahkanev1.InteractionRunPhase) *vahkanev1.InteractionRun {
+		return &vahkanev1.InteractionRun{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "ns",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+				Annotations:       map[string]string{AnnotKeyDiscordInteractionToken: "token"},
+			},
+			Spec: vahkanev1.InteractionRunSpec{
+				DiscordInteractionRef: corev1.LocalObjectReference{Name: "di"},
+				Action:                action,
+				InteractionID:         name,
+			},
+			Status: vahkanev1.InteractionRunStatus{
+				Phase:          phase,
+				CompletionTime: ptr.To(metav1.NewTime(now.Add(-age))),
+			},
+		}
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(
+			di,
+			newRun("old", "build", 2*time.Hour, vahkanev1.InteractionRunPhaseSucceeded),
+			newRun("new", "build", time.Hour, vahkanev1.InteractionRunPhaseSucceeded),
+			newRun("running", "build", 0, vahkanev1.InteractionRunPhaseRunning),
+			newRun("stale", "deploy", 2*defaultInteractionRunTTL, vahkanev1.InteractionRunPhaseFailed),
+			newRun("recent", "deploy", time.Hour, vahkanev1.InteractionRunPhaseFailed),
+		).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewInteractionRunReconciler(k8sClient, k8sClient, k8sClient.Scheme(), nil, recorder)
+	reconcile := func(name string) ctrl.Result {
+		t.Helper()
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "ns"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	get := func(name string) (*vahkanev1.InteractionRun, error) {
+		var run vahkanev1.InteractionRun
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "ns"}, &run)
+		return &run, err
+	}
+
+	// The finished runs lose their tokens and are pruned by the history
+	// limits of the action.
+	reconcile("new")
+	if run, err := get("new"); err != nil || run.Annotations[AnnotKeyDiscordInteractionToken] != "" {
+		t.Errorf("the token should be dropped: %v: %v", run.Annotations, err)
+	}
+	if _, err := get("old"); !k8serrors.IsNotFound(err) {
+		t.Errorf("the old run should be pruned: %v", err)
+	}
+	reconcile("running")
+	if run, err := get("running"); err != nil || run.Annotations[AnnotKeyDiscordInteractionToken] != "token" {
+		t.Errorf("the running run should be left as it is: %v: %v", run.Annotations, err)
+	}
+
+	// The runs of actions without retention are kept for the default TTL.
+	reconcile("stale")
+	if _, err := get("stale"); !k8serrors.IsNotFound(err) {
+		t.Errorf("the stale run should be deleted: %v", err)
+	}
+	if result := reconcile("recent"); result.RequeueAfter <= 0 || result.RequeueAfter > defaultInteractionRunTTL {
+		t.Errorf("the recent run should be requeued until its TTL: %v", result)
+	}
+	if _, err := get("recent"); err != nil {
+		t.Errorf("the recent run should be kept: %v", err)
+	}
+	k8stest.ExpectEventReasons(t, recorder)
+}
@@ -2,14 +2,23 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
-	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -17,31 +26,60 @@ import (
 
 const (
 	LabelKeyJob                     = "vahkane.anqou.net/job"
+	LabelKeyJobGroup                = "vahkane.anqou.net/job-group"
+	AnnotKeyDiscordInteraction      = "vahkane.anqou.net/discord-interaction"
+	AnnotKeyDiscordApplication      = "vahkane.anqou.net/discord-application"
+	AnnotKeyAction                  = "vahkane.anqou.net/action"
 	AnnotKeyDiscordInteractionToken = "vahkane.anqou.net/discord-interaction-token"
+	AnnotKeyUserID                  = "vahkane.anqou.net/user-id"
+	AnnotKeyUserName                = "vahkane.anqou.net/user-name"
+	AnnotKeyInteractionID           = "vahkane.anqou.net/interaction-id"
+	annotKeyReported                = "vahkane.anqou.net/reported"
+
+	eventReasonFollowupSent   = "FollowupSent"
+	eventReasonFollowupFailed = "FollowupFailed"
 )
 
 type JobReconciler struct {
-	Client        client.Client
-	Scheme        *runtime.Scheme
-	namespace     string
-	discordClient discord.Client
+	Client       client.Client
+	Scheme       *runtime.Scheme
+	apiReader    client.Reader
+	applications *ApplicationResolver
+	clientset    kubernetes.Interface
+	recorder     record.EventRecorder
+	audit        audit.Sink
 }
 
+// NewJobReconciler creates a JobReconciler. clientset is used to read the
+// logs of the Jobs' pods; if it is nil, logs are never relayed to Discord.
+// The results of the Jobs are written to auditSink if it is not nil.
 func NewJobReconciler(
 	client client.Client,
+	apiReader client.Reader,
 	scheme *runtime.Scheme,
-	namespace string,
-	discordClient discord.Client,
+	applications *ApplicationResolver,
+	clientset kubernetes.Interface,
+	recorder record.EventRecorder,
+	auditSink audit.Sink,
 ) *JobReconciler {
+	if auditSink == nil {
+		auditSink = audit.Discard
+	}
 	return &JobReconciler{
-		Client:        client,
-		Scheme:        scheme,
-		namespace:     namespace,
-		discordClient: discordClient,
+		Client:       client,
+		Scheme:       scheme,
+		apiReader:    apiReader,
+		applications: applications,
+		clientset:    clientset,
+		recorder:     recorder,
+		audit:        auditSink,
 	}
 }
 
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 
 func (r *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var job batchv1.Job
@@ -73,32 +111,392 @@ func (r *JobReconciler) reconcileJob(ctx context.Context, job *batchv1.Job) erro
 		return nil
 	}
 
-	if !IsJobStatusConditionTrue(job.Status.Conditions, batchv1.JobComplete) &&
-		!IsJobStatusConditionTrue(job.Status.Conditions, batchv1.JobFailed) {
+	if !IsJobFinished(job) {
 		return nil
 	}
 
+	result := vahkanev1.RunResultSucceeded
 	msg := "completed"
 	if IsJobStatusConditionTrue(job.Status.Conditions, batchv1.JobFailed) {
+		result = vahkanev1.RunResultFailed
 		msg = "failed"
 	}
 
-	discordInteractionToken := job.GetAnnotations()[AnnotKeyDiscordInteractionToken]
-	if err := r.discordClient.SendFollowupMessage(
+	di, action, err := r.fetchAction(ctx, job)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the action of the Job: %w", err)
+	}
+
+	if _, ok := job.GetAnnotations()[annotKeyReported]; ok {
+		// Only the deletion of the reported Job may be left.
+		if action != nil && action.Retention != nil {
+			return nil
+		}
+	} else {
+		if di != nil {
+			if err := RecordRun(ctx, r.Client, di, job, result); err != nil {
+				return fmt.Errorf("failed to record the run: %w", err)
+			}
+		}
+		if err := UpdateInteractionRunPhase(ctx, r.Client, r.apiReader, job, InteractionRunPhaseOf(result)); err != nil {
+			return fmt.Errorf("failed to update InteractionRun: %w", err)
+		}
+		if err := ReleaseJobLock(ctx, r.Client, r.apiReader, job); err != nil {
+			return fmt.Errorf("failed to release the job lock: %w", err)
+		}
+
+		// The Job is marked before it is reported so that a retried
+		// reconciliation doesn't report it again. The result is thus sent
+		// at most once: if sending it fails, it is not retried, and the
+		// failure is only logged and recorded as an event. Retrying could
+		// send it twice, and the interaction token may have expired anyway.
+		annots := job.GetAnnotations()
+		annots[annotKeyReported] = "true"
+		job.SetAnnotations(annots)
+		if err := r.Client.Update(ctx, job); err != nil {
+			return fmt.Errorf("failed to mark Job as reported: %w", err)
+		}
+
+		observeJobFinished(job, result)
+		writeRunAudit(ctx, r.audit, job, result)
+		if err := r.sendResult(ctx, job, action, msg, IsResultEphemeral(action, result)); err != nil {
+			logger.Error(err, "failed to send followup messages")
+			r.recorder.Eventf(job, corev1.EventTypeWarning, eventReasonFollowupFailed,
+				"Failed to send the result to Discord: %v", err)
+		} else {
+			r.recorder.Eventf(job, corev1.EventTypeNormal, eventReasonFollowupSent,
+				"Sent the result to Discord: %s", msg)
+		}
+	}
+
+	if action == nil || action.Retention == nil {
+		propagationPolicy := metav1.DeletePropagationBackground
+		if err := r.Client.Delete(ctx, job, &client.DeleteOptions{
+			PropagationPolicy: &propagationPolicy,
+		}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Job: %w", err)
+		}
+		return nil
+	}
+
+	if err := r.pruneJobs(ctx, job, action.Retention); err != nil {
+		return fmt.Errorf("failed to prune Jobs: %w", err)
+	}
+
+	return nil
+}
+
+func (r *JobReconciler) sendResult(
+	ctx context.Context,
+	job *batchv1.Job,
+	action *vahkanev1.DiscordInteractionAction,
+	msg string,
+	ephemeral bool,
+) error {
+	logger := log.FromContext(ctx)
+
+	app, err := r.applications.Resolve(ctx, JobApplicationKey(job))
+	if err != nil {
+		return fmt.Errorf("failed to resolve Discord application: %w", err)
+	}
+
+	if action == nil || action.Logs == nil || r.clientset == nil {
+		return sendRunMessage(ctx, app.Client, job, msg, ephemeral)
+	}
+
+	logs, err := readJobLogs(ctx, r.clientset, job, action.Logs)
+	if err != nil {
+		logger.Error(err, "failed to read logs of the Job")
+		return sendRunMessage(ctx, app.Client, job, msg, ephemeral)
+	}
+
+	interactionToken := job.GetAnnotations()[AnnotKeyDiscordInteractionToken]
+	if action.Logs.Attachment && interactionToken != "" {
+		flags := 0
+		if ephemeral {
+			flags = discord.MessageFlagEphemeral
+		}
+		err := app.Client.SendFollowupMessageWithAttachment(
+			ctx,
+			interactionToken,
+			msg,
+			job.GetName()+".log",
+			[]byte(logs),
+			flags,
+		)
+		if err == nil {
+			return nil
+		}
+		// The result is still worth reporting with the last lines of the logs.
+		logger.Error(err, "failed to send logs as an attachment")
+	}
+	return sendRunMessage(ctx, app.Client, job, embedLogs(msg, logs), ephemeral)
+}
+
+// sendRunMessage sends the message about the run to Discord: as a follow-up of
+// the interaction if obj has its token, or to the report channel of the run
+// otherwise. It does nothing if obj has neither.
+func sendRunMessage(
+	ctx context.Context,
+	discordClient discord.Client,
+	obj metav1.Object,
+	msg string,
+	ephemeral bool,
+) error {
+	annots := obj.GetAnnotations()
+	if token := annots[AnnotKeyDiscordInteractionToken]; token != "" {
+		return SendFollowupMessage(ctx, discordClient, token, msg, ephemeral)
+	}
+	if channelID := annots[AnnotKeyReportChannelID]; channelID != "" {
+		return discordClient.SendChannelMessage(ctx, channelID,
+			fmt.Sprintf("`%s` (%s): %s", annots[AnnotKeyAction], annots[AnnotKeyInteractionRun], msg))
+	}
+	return nil
+}
+
+// IsResultEphemeral reports whether the message reporting the result of the
+// run of the action is visible only to the invoker.
+func IsResultEphemeral(action *vahkanev1.DiscordInteractionAction, result vahkanev1.RunResult) bool {
+	if action == nil || action.Ephemeral == nil {
+		return false
+	}
+	if result == vahkanev1.RunResultSucceeded {
+		return action.Ephemeral.Completed
+	}
+	return action.Ephemeral.Failed
+}
+
+// SendFollowupMessage sends the follow-up message, which is visible only to
+// the invoker if ephemeral is true.
+func SendFollowupMessage(
+	ctx context.Context,
+	discordClient discord.Client,
+	interactionToken, msg string,
+	ephemeral bool,
+) error {
+	if !ephemeral {
+		return discordClient.SendFollowupMessage(ctx, interactionToken, msg)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"content": msg,
+		"flags":   discord.MessageFlagEphemeral,
+	})
+	if err != nil {
+		return err
+	}
+	return discordClient.SendFollowupMessageJSON(ctx, interactionToken, body)
+}
+
+// RecordRun prepends the record of the finished Job to the history of the
+// DiscordInteraction. It does nothing if the Job is already recorded.
+func RecordRun(
+	ctx context.Context,
+	k8sClient client.Client,
+	di *vahkanev1.DiscordInteraction,
+	job *batchv1.Job,
+	result vahkanev1.RunResult,
+) error {
+	return recordRun(ctx, k8sClient, di, job, result, job.Status.StartTime, jobFinishedTime(job))
+}
+
+// writeRunAudit writes the audit entry of the finished object of a run.
+// Failures are only logged because the run has already finished.
+func writeRunAudit(ctx context.Context, sink audit.Sink, obj metav1.Object, result vahkanev1.RunResult) {
+	annots := obj.GetAnnotations()
+	entry := &audit.Entry{
+		Time:               time.Now(),
+		InteractionID:      annots[AnnotKeyInteractionID],
+		DiscordInteraction: obj.GetNamespace() + "/" + annots[AnnotKeyDiscordInteraction],
+		UserID:             annots[AnnotKeyUserID],
+		UserName:           annots[AnnotKeyUserName],
+		Action:             annots[AnnotKeyAction],
+		Decision:           audit.DecisionFinished,
+		JobName:            obj.GetName(),
+		Result:             string(result),
+	}
+	if err := sink.Write(ctx, entry); err != nil {
+		log.FromContext(ctx).Error(err, "failed to write audit entry", "job", obj.GetName())
+	}
+}
+
+// recordRun prepends the record of the finished object of a run to the
+// history of the DiscordInteraction. If startTime or completionTime is nil,
+// the creation time of obj or the current time is recorded instead.
+func recordRun(
+	ctx context.Context,
+	k8sClient client.Client,
+	di *vahkanev1.DiscordInteraction,
+	obj metav1.Object,
+	result vahkanev1.RunResult,
+	startTime, completionTime *metav1.Time,
+) error {
+	for _, record := range di.Status.History {
+		if record.JobName == obj.GetName() {
+			return nil
+		}
+	}
+
+	record := vahkanev1.DiscordInteractionRunRecord{
+		JobName:        obj.GetName(),
+		Action:         obj.GetAnnotations()[AnnotKeyAction],
+		Result:         result,
+		UserID:         obj.GetAnnotations()[AnnotKeyUserID],
+		UserName:       obj.GetAnnotations()[AnnotKeyUserName],
+		StartTime:      startTime,
+		CompletionTime: completionTime,
+	}
+	if record.StartTime == nil {
+		creationTimestamp := obj.GetCreationTimestamp()
+		record.StartTime = &creationTimestamp
+	}
+	if record.CompletionTime == nil {
+		now := metav1.Now()
+		record.CompletionTime = &now
+	}
+
+	limit := int32(vahkanev1.DefaultHistoryLimit)
+	if di.Spec.HistoryLimit != nil {
+		limit = *di.Spec.HistoryLimit
+	}
+	history := append([]vahkanev1.DiscordInteractionRunRecord{record}, di.Status.History...)
+	if int32(len(history)) > limit {
+		history = history[:limit]
+	}
+	di.Status.History = history
+
+	return k8sClient.Status().Update(ctx, di)
+}
+
+// pruneJobs deletes the reported Jobs of the same action as job that exceed
+// the history limits of the retention.
+func (r *JobReconciler) pruneJobs(
+	ctx context.Context,
+	job *batchv1.Job,
+	retention *vahkanev1.DiscordInteractionActionRetention,
+) error {
+	var jobList batchv1.JobList
+	if err := r.Client.List(
 		ctx,
-		discordInteractionToken,
-		msg,
+		&jobList,
+		client.InNamespace(job.GetNamespace()),
+		client.MatchingLabels{LabelKeyJobGroup: job.GetLabels()[LabelKeyJobGroup]},
 	); err != nil {
-		logger.Error(err, "failed to send followup messages")
+		return fmt.Errorf("failed to list Jobs: %w", err)
 	}
 
-	propagationPolicy := metav1.DeletePropagationBackground
-	if err := r.Client.Delete(ctx, job, &client.DeleteOptions{
-		PropagationPolicy: &propagationPolicy,
-	}); err != nil && !k8serrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete Job: %w", err)
+	var succeeded, failed []*batchv1.Job
+	for i := range jobList.Items {
+		item := &jobList.Items[i]
+		// The cache may not reflect the annotation just added to job yet.
+		if _, ok := item.GetAnnotations()[annotKeyReported]; !ok && item.GetName() != job.GetName() {
+			continue
+		}
+		if IsJobStatusConditionTrue(item.Status.Conditions, batchv1.JobComplete) {
+			succeeded = append(succeeded, item)
+		} else if IsJobStatusConditionTrue(item.Status.Conditions, batchv1.JobFailed) {
+			failed = append(failed, item)
+		}
 	}
 
+	for _, e := range []struct {
+		jobs  []*batchv1.Job
+		limit *int32
+	}{
+		{jobs: succeeded, limit: retention.SuccessfulJobsHistoryLimit},
+		{jobs: failed, limit: retention.FailedJobsHistoryLimit},
+	} {
+		if e.limit == nil || int32(len(e.jobs)) <= *e.limit {
+			continue
+		}
+		sort.Slice(e.jobs, func(i, j int) bool {
+			return jobFinishedTime(e.jobs[j]).Before(jobFinishedTime(e.jobs[i]))
+		})
+		propagationPolicy := metav1.DeletePropagationBackground
+		for _, item := range e.jobs[*e.limit:] {
+			if err := r.Client.Delete(ctx, item, &client.DeleteOptions{
+				PropagationPolicy: &propagationPolicy,
+			}); err != nil && !k8serrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete Job: %s: %w", item.GetName(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchAction returns the DiscordInteraction and its action that created the
+// Job. It returns nil if the DiscordInteraction or the action no longer exists.
+func (r *JobReconciler) fetchAction(
+	ctx context.Context,
+	job *batchv1.Job,
+) (*vahkanev1.DiscordInteraction, *vahkanev1.DiscordInteractionAction, error) {
+	diName, ok := job.GetAnnotations()[AnnotKeyDiscordInteraction]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	var di vahkanev1.DiscordInteraction
+	if err := r.Client.Get(
+		ctx,
+		types.NamespacedName{Name: diName, Namespace: job.GetNamespace()},
+		&di,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get DiscordInteraction: %w", err)
+	}
+
+	actionName := job.GetAnnotations()[AnnotKeyAction]
+	for i := range di.Spec.Actions {
+		if di.Spec.Actions[i].Name == actionName {
+			return &di, &di.Spec.Actions[i], nil
+		}
+	}
+	return &di, nil, nil
+}
+
+func observeJobFinished(job *batchv1.Job, result vahkanev1.RunResult) {
+	diName := job.GetAnnotations()[AnnotKeyDiscordInteraction]
+	actionName := job.GetAnnotations()[AnnotKeyAction]
+	metrics.JobsFinishedTotal.WithLabelValues(diName, actionName, string(result)).Inc()
+
+	finishedTime := jobFinishedTime(job)
+	if job.Status.StartTime != nil && finishedTime != nil {
+		metrics.JobDurationSeconds.
+			WithLabelValues(diName, actionName, string(result)).
+			Observe(finishedTime.Sub(job.Status.StartTime.Time).Seconds())
+	}
+}
+
+// JobApplicationKey returns the key of the application of the interaction that
+// created the Job or the object of a resource action. It is zero for the
+// default application.
+func JobApplicationKey(job metav1.Object) types.NamespacedName {
+	name := job.GetAnnotations()[AnnotKeyDiscordApplication]
+	if name == "" {
+		return types.NamespacedName{}
+	}
+	return types.NamespacedName{Name: name, Namespace: job.GetNamespace()}
+}
+
+// IsJobFinished returns true if the Job has completed or failed.
+func IsJobFinished(job *batchv1.Job) bool {
+	return IsJobStatusConditionTrue(job.Status.Conditions, batchv1.JobComplete) ||
+		IsJobStatusConditionTrue(job.Status.Conditions, batchv1.JobFailed)
+}
+
+// jobFinishedTime returns the time when the Job completed or failed.
+func jobFinishedTime(job *batchv1.Job) *metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime
+	}
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) &&
+			cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.DeepCopy()
+		}
+	}
 	return nil
 }
 
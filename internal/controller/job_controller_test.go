@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	"go.uber.org/mock/gomock"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("Job Controller", func() {
+	Context("When reconciling a finished Job", func() {
+		var mockCtrl *gomock.Controller
+		var discordClient *discord.MockClient
+		var ns string
+		var reconciler *JobReconciler
+
+		BeforeEach(func(ctx SpecContext) {
+			var t reporter
+			mockCtrl = gomock.NewController(t)
+			discordClient = discord.NewMockClient(mockCtrl)
+
+			ns = gensym("ns")
+			err := k8sClient.Create(
+				ctx,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			reconciler = NewJobReconciler(
+				k8sClient,
+				k8sClient,
+				scheme.Scheme,
+				NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, discord.NewRealClient),
+				nil,
+				&record.FakeRecorder{},
+				nil,
+			)
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		createFinishedJob := func(
+			ctx SpecContext,
+			name string,
+			condType batchv1.JobConditionType,
+			finishedAt time.Time,
+		) *batchv1.Job {
+			var job batchv1.Job
+			job.SetName(name)
+			job.SetNamespace(ns)
+			job.SetLabels(map[string]string{LabelKeyJob: "true", LabelKeyJobGroup: "job-test"})
+			job.SetAnnotations(map[string]string{
+				AnnotKeyDiscordInteraction:      "test",
+				AnnotKeyAction:                  "action",
+				AnnotKeyDiscordInteractionToken: name + "-token",
+				AnnotKeyUserID:                  "user-id",
+				AnnotKeyUserName:                "user-name",
+			})
+			job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+			job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "main", Image: "busybox"}}
+			Expect(k8sClient.Create(ctx, &job)).To(Succeed())
+
+			startTime := metav1.NewTime(finishedAt.Add(-time.Minute))
+			job.Status.StartTime = &startTime
+			job.Status.Conditions = []batchv1.JobCondition{{
+				Type:               condType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(finishedAt),
+			}}
+			if condType == batchv1.JobComplete {
+				completionTime := metav1.NewTime(finishedAt)
+				job.Status.CompletionTime = &completionTime
+				job.Status.Succeeded = 1
+			} else {
+				job.Status.Failed = 1
+			}
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+			return &job
+		}
+
+		It("should keep finished Jobs according to the retention and record the history", func(ctx SpecContext) {
+			var di vahkanev1.DiscordInteraction
+			di.SetName("test")
+			di.SetNamespace(ns)
+			di.Spec.GuildID = "test-guild"
+			di.Spec.Commands = []string{}
+			di.Spec.Actions = []vahkanev1.DiscordInteractionAction{{
+				Name:    "action",
+				Pattern: "{}",
+				Retention: &vahkanev1.DiscordInteractionActionRetention{
+					SuccessfulJobsHistoryLimit: ptr.To[int32](1),
+				},
+			}}
+			di.Spec.HistoryLimit = ptr.To[int32](1)
+			Expect(k8sClient.Create(ctx, &di)).To(Succeed())
+
+			now := time.Now().Truncate(time.Second)
+			job1 := createFinishedJob(ctx, "job-test-1", batchv1.JobComplete, now.Add(-time.Hour))
+			job2 := createFinishedJob(ctx, "job-test-2", batchv1.JobComplete, now)
+
+			discordClient.EXPECT().
+				SendFollowupMessage(gomock.Any(), gomock.Eq("job-test-1-token"), gomock.Eq("completed")).
+				Return(nil)
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: job1.GetName(), Namespace: ns,
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			// The Job should be kept and marked as reported.
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: job1.GetName(), Namespace: ns}, job1)).To(Succeed())
+			Expect(job1.GetAnnotations()).To(HaveKey(annotKeyReported))
+
+			// Reconciling a reported Job should do nothing.
+			_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: job1.GetName(), Namespace: ns,
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			discordClient.EXPECT().
+				SendFollowupMessage(gomock.Any(), gomock.Eq("job-test-2-token"), gomock.Eq("completed")).
+				Return(nil)
+			_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: job2.GetName(), Namespace: ns,
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			// The older Job should be pruned.
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: job1.GetName(), Namespace: ns}, job1)
+			if err == nil {
+				Expect(job1.GetDeletionTimestamp()).NotTo(BeNil())
+			} else {
+				Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: job2.GetName(), Namespace: ns}, job2)).To(Succeed())
+			Expect(job2.GetDeletionTimestamp()).To(BeNil())
+
+			// The history should be bounded by the limit.
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test", Namespace: ns}, &di)).To(Succeed())
+			Expect(di.Status.History).To(HaveLen(1))
+			Expect(di.Status.History[0].JobName).To(Equal(job2.GetName()))
+			Expect(di.Status.History[0].Action).To(Equal("action"))
+			Expect(di.Status.History[0].UserID).To(Equal("user-id"))
+			Expect(di.Status.History[0].Result).To(Equal(vahkanev1.RunResultSucceeded))
+		})
+
+		It("should delete the Job if no retention is specified", func(ctx SpecContext) {
+			job := createFinishedJob(ctx, "job-test-3", batchv1.JobFailed, time.Now())
+
+			discordClient.EXPECT().
+				SendFollowupMessage(gomock.Any(), gomock.Eq("job-test-3-token"), gomock.Eq("failed")).
+				Return(nil)
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: job.GetName(), Namespace: ns,
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: job.GetName(), Namespace: ns}, job)
+			if err == nil {
+				Expect(job.GetDeletionTimestamp()).NotTo(BeNil())
+			} else {
+				Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			}
+		})
+	})
+})
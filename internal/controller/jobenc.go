@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+)
+
+var jobEncoding = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// jobRunNamePrefixLength is the length of the job name kept at the beginning
+// of a run's Job name. It keeps the name short enough to be used as a label
+// value (at most 63 characters).
+const jobRunNamePrefixLength = 30
+
+func encodeHash(parts ...string) string {
+	var buf bytes.Buffer
+	for i, part := range parts {
+		if i > 0 {
+			buf.WriteByte(0)
+		}
+		buf.WriteString(part)
+	}
+	hash := sha256.Sum224(buf.Bytes())
+
+	// set hash to x
+	x := big.NewInt(0)
+	for _, b := range hash {
+		x.Lsh(x, 8)
+		x.Or(x, big.NewInt(int64(b)))
+	}
+
+	return encodeInt(x)
+}
+
+// encodeInt encodes x using jobEncoding map, least significant digit first.
+// x is consumed.
+func encodeInt(x *big.Int) string {
+	y := big.NewInt(int64(len(jobEncoding)))
+	var encoded bytes.Buffer
+	for x.Cmp(y) >= 0 {
+		var m big.Int
+		x.DivMod(x, y, &m)
+		c := jobEncoding[m.Int64()]
+		encoded.WriteByte(c)
+	}
+	encoded.WriteByte(jobEncoding[x.Int64()])
+
+	return encoded.String()
+}
+
+// JobGroupName returns the name identifying the Jobs of the action. It is
+// shared by all runs of the action.
+func JobGroupName(diName string, action *vahkanev1.DiscordInteractionAction) string {
+	return fmt.Sprintf("job-%s", encodeHash(diName, action.Name))
+}
+
+// RunJobName returns the name of the Job for a run of the action
+// triggered by the interaction.
+func RunJobName(jobName, interactionID string) string {
+	prefix := jobName
+	if len(prefix) > jobRunNamePrefixLength {
+		prefix = prefix[:jobRunNamePrefixLength]
+	}
+	return fmt.Sprintf("%s-%s", prefix, encodeHash(jobName, interactionID)[:16])
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"math/big"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+)
+
+func TestJobGroupName(t *testing.T) {
+	jobName := JobGroupName("diName", &vahkanev1.DiscordInteractionAction{Name: "action"})
+	if jobName != "job-5c9aordx16dqd2mgii4bcliw986krokvd9e9oz4gt65" {
+		t.Errorf("JobGroupName returns unexpected value: %s", jobName)
+	}
+}
+
+func TestRunJobName(t *testing.T) {
+	jobName := JobGroupName("diName", &vahkanev1.DiscordInteractionAction{Name: "action"})
+	runJobName1 := RunJobName(jobName, "1234")
+	runJobName2 := RunJobName(jobName, "5678")
+	if runJobName1 == runJobName2 {
+		t.Errorf("RunJobName returns the same value for different interactions: %s", runJobName1)
+	}
+	if runJobName1 != RunJobName(jobName, "1234") {
+		t.Errorf("RunJobName is not deterministic: %s", runJobName1)
+	}
+	if len(runJobName1) > 63 || runJobName1[:jobRunNamePrefixLength] != jobName[:jobRunNamePrefixLength] {
+		t.Errorf("RunJobName returns unexpected value: %s", runJobName1)
+	}
+}
+
+func TestEncodeInt(t *testing.T) {
+	for _, tt := range []struct {
+		x        int64
+		expected string
+	}{
+		{x: 0, expected: "0"},
+		{x: 35, expected: "z"},
+		// A value equal to the base has two digits; it used to index past the
+		// end of jobEncoding.
+		{x: 36, expected: "01"},
+		{x: 36*36 - 1, expected: "zz"},
+		{x: 36 * 36, expected: "001"},
+	} {
+		if encoded := encodeInt(big.NewInt(tt.x)); encoded != tt.expected {
+			t.Errorf("encodeInt(%d) = %s, want %s", tt.x, encoded, tt.expected)
+		}
+	}
+}
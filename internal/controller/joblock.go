@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// jobLockGracePeriod is how long a lock is kept without its holder Job,
+// which is created right after the lock is acquired.
+const jobLockGracePeriod = 30 * time.Second
+
+var (
+	ErrAlreadyRunning       = errors.New("already running")
+	ErrDuplicateInteraction = errors.New("duplicate interaction")
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
+
+// AcquireJobLock makes the run the only one of its Job group, so that
+// webhook servers on different replicas can't start the same action twice.
+// The lock is a Lease named after the Job group whose holder is the name of
+// the Job (or the object of a resource action) of the run. The lock is
+// released when the holder is reported or cancelled, or after it disappears,
+// and the Lease is garbage-collected with the DiscordInteraction.
+//
+// It returns ErrAlreadyRunning if another run holds the lock, and
+// ErrDuplicateInteraction if the run already holds the lock, i.e.,
+// the same interaction has already been handled. apiReader reads the Lease
+// and its holder bypassing the cache, which may not have them yet if they
+// have just been created by another replica.
+func AcquireJobLock(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	jobGroup, runName string,
+) error {
+	var lease coordinationv1.Lease
+	lease.SetName(jobGroup)
+	lease.SetNamespace(di.GetNamespace())
+	now := metav1.NowMicro()
+	lease.Spec.HolderIdentity = &runName
+	lease.Spec.AcquireTime = &now
+	if err := controllerutil.SetOwnerReference(di, &lease, k8sClient.Scheme()); err != nil {
+		return fmt.Errorf("failed to set owner reference: %w", err)
+	}
+	err := k8sClient.Create(ctx, &lease)
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create Lease: %w", err)
+	}
+
+	if err := apiReader.Get(ctx, client.ObjectKeyFromObject(&lease), &lease); err != nil {
+		return fmt.Errorf("failed to get Lease: %w", err)
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder == runName {
+		return ErrDuplicateInteraction
+	}
+
+	if holder != "" {
+		exists, finished, err := getRunState(
+			ctx,
+			apiReader,
+			action,
+			types.NamespacedName{Name: holder, Namespace: di.GetNamespace()},
+		)
+		if err != nil {
+			return err
+		}
+		if exists && !finished {
+			return ErrAlreadyRunning
+		}
+		if !exists && lease.Spec.AcquireTime != nil &&
+			now.Sub(lease.Spec.AcquireTime.Time) < jobLockGracePeriod {
+			// The holder Job may be about to be created.
+			return ErrAlreadyRunning
+		}
+	}
+
+	// The holder is gone. Take over the lock; the update fails with a
+	// conflict if another replica has taken it over first.
+	lease.Spec.HolderIdentity = &runName
+	lease.Spec.AcquireTime = &now
+	if err := k8sClient.Update(ctx, &lease); err != nil {
+		if k8serrors.IsConflict(err) {
+			return ErrAlreadyRunning
+		}
+		return fmt.Errorf("failed to update Lease: %w", err)
+	}
+	return nil
+}
+
+// ReleaseJobLock releases the lock held by the Job or the object of a
+// resource action, if any. apiReader reads the Lease bypassing the cache,
+// which may not have it yet if the lock has just been acquired.
+func ReleaseJobLock(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	job metav1.Object,
+) error {
+	jobGroup, ok := job.GetLabels()[LabelKeyJobGroup]
+	if !ok {
+		return nil
+	}
+
+	var lease coordinationv1.Lease
+	if err := apiReader.Get(
+		ctx,
+		types.NamespacedName{Name: jobGroup, Namespace: job.GetNamespace()},
+		&lease,
+	); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != job.GetName() {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	if err := k8sClient.Update(ctx, &lease); err != nil {
+		if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+			// Someone else has taken over the lock.
+			return nil
+		}
+		return fmt.Errorf("failed to update Lease: %w", err)
+	}
+	return nil
+}
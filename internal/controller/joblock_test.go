@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestJobLock(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(di).Build()
+	action := &vahkanev1.DiscordInteractionAction{Name: "action"}
+
+	newJob := func(name string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns",
+				Labels:    map[string]string{LabelKeyJobGroup: "group"},
+			},
+		}
+	}
+
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run1"); !errors.Is(err, ErrDuplicateInteraction) {
+		t.Errorf("the same run should be a duplicate: %v", err)
+	}
+	// The holder Job is about to be created.
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run2"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("the lock should be held within the grace period: %v", err)
+	}
+
+	job1 := newJob("run1")
+	if err := k8sClient.Create(ctx, job1); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run2"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("the lock should be held by the running Job: %v", err)
+	}
+
+	// Another group is not affected.
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "other", "run3"); err != nil {
+		t.Errorf("the lock of another group should be acquired: %v", err)
+	}
+
+	// The lock is released when the Job finishes.
+	if err := ReleaseJobLock(ctx, k8sClient, k8sClient, newJob("run2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run2"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("a Job not holding the lock should not release it: %v", err)
+	}
+	if err := ReleaseJobLock(ctx, k8sClient, k8sClient, job1); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run2"); err != nil {
+		t.Errorf("the released lock should be acquired: %v", err)
+	}
+
+	// A lock whose holder has been finished for long is taken over.
+	var lease coordinationv1.Lease
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "group", Namespace: "ns"}, &lease); err != nil {
+		t.Fatal(err)
+	}
+	if len(lease.GetOwnerReferences()) != 1 || lease.GetOwnerReferences()[0].UID != di.GetUID() {
+		t.Errorf("the Lease should be owned by the DiscordInteraction: %v", lease.GetOwnerReferences())
+	}
+	acquireTime := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+	lease.Spec.AcquireTime = &acquireTime
+	if err := k8sClient.Update(ctx, &lease); err != nil {
+		t.Fatal(err)
+	}
+	job2 := newJob("run2")
+	job2.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := k8sClient.Create(ctx, job2); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, "group", "run4"); err != nil {
+		t.Errorf("the lock of the finished Job should be taken over: %v", err)
+	}
+}
+
+func TestReleaseJobLockBeforeCacheSync(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	apiReader := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	// The cache has seen neither the Lease nor the Jobs.
+	k8sClient := k8stest.NewStaleCacheClient(apiReader, k8stest.NewFakeClientBuilder(t).Build())
+	action := &di.Spec.Actions[0]
+	jobGroup := JobGroupName(di.Name, action)
+	holderOf := func() string {
+		var lease coordinationv1.Lease
+		if err := apiReader.Get(ctx, types.NamespacedName{Name: jobGroup, Namespace: "ns"}, &lease); err != nil {
+			t.Fatal(err)
+		}
+		if lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+		return *lease.Spec.HolderIdentity
+	}
+
+	if err := AcquireJobLock(ctx, k8sClient, apiReader, di, action, jobGroup, "run1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseJobLock(ctx, k8sClient, apiReader, newRunLockHolder("ns", jobGroup, "run1")); err != nil {
+		t.Fatal(err)
+	}
+	if holder := holderOf(); holder != "" {
+		t.Errorf("the lock should be released: %s", holder)
+	}
+
+	// A run refused after taking the lock releases it, so the next run
+	// doesn't wait for the grace period.
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "running",
+			Namespace:   "ns",
+			Labels:      map[string]string{LabelKeyJobGroup: jobGroup},
+			Annotations: map[string]string{AnnotKeyDiscordInteraction: di.Name},
+		},
+	}
+	if err := apiReader.Create(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	inv := &RunInvocation{InteractionID: "1234"}
+	if _, _, err := StartRun(ctx, k8sClient, apiReader, di, action, inv); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("the run should be refused: %v", err)
+	}
+	if holder := holderOf(); holder != "" {
+		t.Errorf("the refused run should release the lock: %s", holder)
+	}
+}
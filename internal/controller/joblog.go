@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// maxJobLogSize is the maximum size of the logs read from the pod. It is below
+// the limit of the size of the files attached to a Discord message.
+const maxJobLogSize = 8 << 20
+
+var errNoPodFound = errors.New("no pod found for the Job")
+
+// readJobLogs reads the logs of the latest pod created by the Job. The logs
+// are cut at maxJobLogSize.
+func readJobLogs(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	job *batchv1.Job,
+	setting *vahkanev1.DiscordInteractionActionLogs,
+) (string, error) {
+	if job.Spec.Selector == nil {
+		return "", errNoPodFound
+	}
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert the selector of the Job: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(job.GetNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods of the Job: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return "", errNoPodFound
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.Before(&pods.Items[j].CreationTimestamp)
+	})
+	pod := &pods.Items[len(pods.Items)-1]
+
+	container := setting.Container
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	logs, err := clientset.CoreV1().Pods(pod.GetNamespace()).GetLogs(pod.GetName(), &corev1.PodLogOptions{
+		Container:  container,
+		TailLines:  setting.TailLines,
+		LimitBytes: ptr.To[int64](maxJobLogSize),
+	}).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get logs of the pod: %s: %w", pod.GetName(), err)
+	}
+
+	return string(logs), nil
+}
+
+// embedLogs appends the logs to the message as a code block. If the result
+// exceeds the maximum length of a Discord message, the head of the logs is
+// dropped so that the last lines remain visible.
+func embedLogs(msg, logs string) string {
+	const prefix, suffix = "\n```\n", "\n```"
+	logs = strings.TrimRight(logs, "\n")
+	if logs == "" {
+		return msg
+	}
+	room := discord.MaxContentLength - len(msg) - len(prefix) - len(suffix)
+	if room <= 0 {
+		return msg
+	}
+	if len(logs) > room {
+		logs = logs[len(logs)-room:]
+		for len(logs) > 0 && !utf8.RuneStart(logs[0]) {
+			logs = logs[1:]
+		}
+	}
+	return msg + prefix + logs + suffix
+}
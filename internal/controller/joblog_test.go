@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func TestEmbedLogs(t *testing.T) {
+	table := []struct {
+		msg, logs, expected string
+	}{
+		{msg: "completed", logs: "", expected: "completed"},
+		{msg: "completed", logs: "a\nb\n\n", expected: "completed\n```\na\nb\n```"},
+	}
+	for _, e := range table {
+		if got := embedLogs(e.msg, e.logs); got != e.expected {
+			t.Errorf("embedLogs returns unexpected value: %q: %q: %q", e.msg, e.logs, got)
+		}
+	}
+
+	got := embedLogs("failed", strings.Repeat("x", discord.MaxContentLength)+"\nlast line")
+	if len(got) != discord.MaxContentLength {
+		t.Errorf("embedLogs returns too long message: %d", len(got))
+	}
+	if !strings.HasPrefix(got, "failed\n```\n") || !strings.HasSuffix(got, "last line\n```") {
+		t.Errorf("embedLogs does not keep the last lines: %q", got[len(got)-20:])
+	}
+}
+
+func TestReadJobLogs(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "job"}},
+		},
+	}
+	newPod := func(name string, created time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "ns",
+				Labels:            map[string]string{"job-name": "job"},
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name}, {Name: "sidecar"}}},
+		}
+	}
+	now := time.Now()
+	clientset := fake.NewSimpleClientset(
+		newPod("job-new", now),
+		newPod("job-old", now.Add(-time.Minute)),
+	)
+
+	ctx := context.Background()
+	logs, err := readJobLogs(ctx, clientset, job, &vahkanev1.DiscordInteractionActionLogs{TailLines: ptr.To[int64](10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs != "fake logs" {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+
+	// The logs of the first container of the latest pod are read.
+	var opts *corev1.PodLogOptions
+	for _, action := range clientset.Actions() {
+		if action.GetSubresource() == "log" {
+			opts = action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions)
+		}
+	}
+	if opts == nil {
+		t.Fatalf("the logs were not read: %v", clientset.Actions())
+	}
+	if opts.Container != "job-new" || opts.TailLines == nil || *opts.TailLines != 10 ||
+		opts.LimitBytes == nil || *opts.LimitBytes != maxJobLogSize {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	if _, err := readJobLogs(ctx, fake.NewSimpleClientset(), job, &vahkanev1.DiscordInteractionActionLogs{}); !errors.Is(err, errNoPodFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
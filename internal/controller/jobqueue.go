@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// AnnotKeyQueued marks the Jobs that are created suspended because their
+// DiscordInteraction has reached MaxConcurrentJobs.
+const AnnotKeyQueued = "vahkane.anqou.net/queued"
+
+const (
+	eventReasonJobResumed       = "JobResumed"
+	eventReasonQueuedJobExpired = "QueuedJobExpired"
+)
+
+// jobQueueLockHolder is the holder of the concurrency lock taken by
+// JobQueueReconciler.
+const jobQueueLockHolder = "jobqueue"
+
+// ListDiscordInteractionJobs returns the running and the queued Jobs of the
+// DiscordInteraction. The queued Jobs are sorted by their creation time, and
+// then by their names.
+func ListDiscordInteractionJobs(
+	ctx context.Context,
+	k8sClient client.Reader,
+	di *vahkanev1.DiscordInteraction,
+) ([]batchv1.Job, []batchv1.Job, error) {
+	var jobList batchv1.JobList
+	if err := k8sClient.List(
+		ctx,
+		&jobList,
+		client.InNamespace(di.GetNamespace()),
+		client.HasLabels{LabelKeyJob},
+	); err != nil {
+		return nil, nil, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+
+	var running, queued []batchv1.Job
+	for _, job := range jobList.Items {
+		if job.GetAnnotations()[AnnotKeyDiscordInteraction] != di.GetName() ||
+			IsJobFinished(&job) || !job.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		if _, ok := job.GetAnnotations()[AnnotKeyQueued]; ok {
+			queued = append(queued, job)
+		} else {
+			running = append(running, job)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		if queued[i].CreationTimestamp.Equal(&queued[j].CreationTimestamp) {
+			return queued[i].GetName() < queued[j].GetName()
+		}
+		return queued[i].CreationTimestamp.Before(&queued[j].CreationTimestamp)
+	})
+	return running, queued, nil
+}
+
+// JobQueueReconciler resumes the queued Jobs of DiscordInteractions as their
+// running Jobs finish. The queued Jobs of interactions are cancelled once the
+// tokens of the interactions expire, since their results could no longer be
+// reported.
+type JobQueueReconciler struct {
+	Client    client.Client
+	Scheme    *runtime.Scheme
+	apiReader client.Reader
+	recorder  record.EventRecorder
+}
+
+func NewJobQueueReconciler(
+	client client.Client,
+	apiReader client.Reader,
+	scheme *runtime.Scheme,
+	recorder record.EventRecorder,
+) *JobQueueReconciler {
+	return &JobQueueReconciler{
+		Client:    client,
+		Scheme:    scheme,
+		apiReader: apiReader,
+		recorder:  recorder,
+	}
+}
+
+func (r *JobQueueReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var di vahkanev1.DiscordInteraction
+	if err := r.Client.Get(ctx, req.NamespacedName, &di); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if di.Spec.MaxConcurrentJobs != nil {
+		// The webhook servers must not start Jobs while the Jobs are
+		// counted and resumed.
+		unlock, err := lockConcurrency(ctx, r.Client, r.apiReader, &di, jobQueueLockHolder)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		defer unlock()
+	}
+
+	running, queued, err := ListDiscordInteractionJobs(ctx, r.apiReader, &di)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	queued, nextExpiry, err := r.expireQueuedJobs(ctx, &di, queued)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	free := len(queued)
+	if di.Spec.MaxConcurrentJobs != nil {
+		free = min(free, int(*di.Spec.MaxConcurrentJobs)-len(running))
+	}
+	for i := 0; i < free; i++ {
+		job := &queued[i]
+		annots := job.GetAnnotations()
+		delete(annots, AnnotKeyQueued)
+		job.SetAnnotations(annots)
+		suspend := false
+		job.Spec.Suspend = &suspend
+		if err := r.Client.Update(ctx, job); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to resume Job: %s: %w", job.GetName(), err)
+		}
+		if err := UpdateInteractionRunPhase(
+			ctx, r.Client, r.apiReader, job, vahkanev1.InteractionRunPhaseRunning,
+		); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update InteractionRun: %w", err)
+		}
+		logger.Info("resumed queued Job", "job", job.GetName())
+		r.recorder.Eventf(job, corev1.EventTypeNormal, eventReasonJobResumed,
+			"Resumed the queued Job because a running Job has finished")
+	}
+
+	return ctrl.Result{RequeueAfter: nextExpiry}, nil
+}
+
+// expireQueuedJobs cancels the queued Jobs whose interaction tokens have
+// expired. It returns the Jobs left in the queue and how long it is until the
+// next one expires, or 0 if none will.
+func (r *JobQueueReconciler) expireQueuedJobs(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	queued []batchv1.Job,
+) ([]batchv1.Job, time.Duration, error) {
+	logger := log.FromContext(ctx)
+
+	left := make([]batchv1.Job, 0, len(queued))
+	var nextExpiry time.Duration
+	for i := range queued {
+		job := &queued[i]
+		// The Jobs of runs created by hand are reported to the channel and
+		// never expire.
+		if job.GetAnnotations()[AnnotKeyDiscordInteractionToken] == "" {
+			left = append(left, *job)
+			continue
+		}
+		if ttl := time.Until(job.CreationTimestamp.Add(discord.InteractionTokenLifetime)); ttl > 0 {
+			left = append(left, *job)
+			if nextExpiry == 0 || ttl < nextExpiry {
+				nextExpiry = ttl
+			}
+			continue
+		}
+
+		if err := RecordRun(ctx, r.Client, di, job, vahkanev1.RunResultCancelled); err != nil {
+			return nil, 0, fmt.Errorf("failed to record the run: %w", err)
+		}
+		if err := UpdateInteractionRunPhase(
+			ctx, r.Client, r.apiReader, job, vahkanev1.InteractionRunPhaseCancelled,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to update InteractionRun: %w", err)
+		}
+		propagationPolicy := metav1.DeletePropagationBackground
+		if err := r.Client.Delete(ctx, job, &client.DeleteOptions{
+			PropagationPolicy: &propagationPolicy,
+		}); err != nil && !k8serrors.IsNotFound(err) {
+			return nil, 0, fmt.Errorf("failed to delete Job: %s: %w", job.GetName(), err)
+		}
+		if err := ReleaseJobLock(ctx, r.Client, r.apiReader, job); err != nil {
+			return nil, 0, fmt.Errorf("failed to release the job lock: %w", err)
+		}
+		logger.Info("cancelled expired queued Job", "job", job.GetName())
+		r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonQueuedJobExpired,
+			"Cancelled the queued Job %s because its interaction token has expired", job.GetName())
+	}
+	return left, nextExpiry, nil
+}
+
+func (r *JobQueueReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&vahkanev1.DiscordInteraction{}).
+		// Jobs are mapped to their DiscordInteraction on deletion too, so
+		// cancelled Jobs free their slots.
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(
+			func(_ context.Context, obj client.Object) []reconcile.Request {
+				if _, ok := obj.GetLabels()[LabelKeyJob]; !ok {
+					return nil
+				}
+				diName, ok := obj.GetAnnotations()[AnnotKeyDiscordInteraction]
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{
+					Name:      diName,
+					Namespace: obj.GetNamespace(),
+				}}}
+			},
+		)).
+		Named("jobqueue").
+		Complete(r)
+}
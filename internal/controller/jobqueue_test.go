@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestJobQueueReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			MaxConcurrentJobs:      ptr.To[int32](2),
+			ConcurrencyLimitPolicy: vahkanev1.ConcurrencyLimitPolicyQueue,
+		},
+	}
+	now := time.Now()
+	newJob := func(name string, created time.Time, queued, finished bool) *batchv1.Job {
+		// Every Job but the finished one follows up its interaction.
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "ns",
+				CreationTimestamp: metav1.NewTime(created),
+				Labels:            map[string]string{LabelKeyJob: "true"},
+				Annotations: map[string]string{
+					AnnotKeyDiscordInteraction:      "di",
+					AnnotKeyDiscordInteractionToken: "token-" + name,
+				},
+			},
+		}
+		if queued {
+			job.Annotations[AnnotKeyQueued] = "true"
+			job.Spec.Suspend = ptr.To(true)
+		}
+		if finished {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		}
+		return job
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(
+			di,
+			newJob("running", now, false, false),
+			newJob("finished", now, false, true),
+			newJob("queued-2", now.Add(2*time.Second), true, false),
+			newJob("queued-1b", now.Add(time.Second), true, false),
+			newJob("queued-1a", now.Add(time.Second), true, false),
+			newJob("expired", now.Add(-discord.InteractionTokenLifetime), true, false),
+		).
+		WithStatusSubresource(di).
+		Build()
+
+	running, queued, err := ListDiscordInteractionJobs(ctx, k8sClient, di)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, job := range queued {
+		names = append(names, job.GetName())
+	}
+	if len(running) != 1 || !reflect.DeepEqual(names, []string{"expired", "queued-1a", "queued-1b", "queued-2"}) {
+		t.Fatalf("unexpected Jobs: %v: %v", running, names)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewJobQueueReconciler(k8sClient, k8sClient, k8sClient.Scheme(), recorder)
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+		Name: "di", Namespace: "ns",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > discord.InteractionTokenLifetime+time.Minute {
+		t.Errorf("the reconciler should wait for the next expiry: %v", result)
+	}
+
+	// The Job queued longer than the lifetime of its token is cancelled.
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "expired", Namespace: "ns"}, &job); !k8serrors.IsNotFound(err) {
+		t.Errorf("the expired Job should be deleted: %v", err)
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(di), di); err != nil {
+		t.Fatal(err)
+	}
+	if len(di.Status.History) != 1 || di.Status.History[0].Result != vahkanev1.RunResultCancelled {
+		t.Errorf("the expired Job should be recorded as cancelled: %v", di.Status.History)
+	}
+
+	// Only the oldest queued Job fits in the limit.
+	isQueued := func(name string) bool {
+		t.Helper()
+		var job batchv1.Job
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "ns"}, &job); err != nil {
+			t.Fatal(err)
+		}
+		_, ok := job.GetAnnotations()[AnnotKeyQueued]
+		return ok || ptr.Deref(job.Spec.Suspend, false)
+	}
+	if isQueued("queued-1a") {
+		t.Error("the oldest queued Job should be resumed")
+	}
+	if !isQueued("queued-1b") || !isQueued("queued-2") {
+		t.Error("the other queued Jobs should stay queued")
+	}
+
+	// The concurrency lock is released.
+	var lease coordinationv1.Lease
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: concurrencyLockName("di"), Namespace: "ns"}, &lease); !k8serrors.IsNotFound(err) {
+		t.Errorf("the concurrency lock should be released: %v", err)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonQueuedJobExpired, eventReasonJobResumed)
+}
+
+func TestExpireQueuedJobsReleasesJobLock(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+	}
+	expired := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "expired",
+			Namespace:         "ns",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-discord.InteractionTokenLifetime)),
+			Labels:            map[string]string{LabelKeyJob: "true", LabelKeyJobGroup: "group"},
+			Annotations: map[string]string{
+				AnnotKeyDiscordInteraction:      "di",
+				AnnotKeyDiscordInteractionToken: "token",
+				AnnotKeyQueued:                  "true",
+			},
+		},
+	}
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "ns"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: ptr.To("expired")},
+	}
+	apiReader := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, expired.DeepCopy(), lease).
+		WithStatusSubresource(di).
+		Build()
+	// The cache has not seen the Lease yet.
+	cache := k8stest.NewFakeClientBuilder(t).WithObjects(di).Build()
+	k8sClient := k8stest.NewStaleCacheClient(apiReader, cache)
+
+	reconciler := NewJobQueueReconciler(k8sClient, apiReader, k8sClient.Scheme(), &record.FakeRecorder{})
+	left, _, err := reconciler.expireQueuedJobs(ctx, di, []batchv1.Job{expired})
+	if err != nil || len(left) != 0 {
+		t.Fatalf("the Job should be expired: %v: %v", left, err)
+	}
+	if err := apiReader.Get(ctx, client.ObjectKeyFromObject(lease), lease); err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil {
+		t.Errorf("the job lock of the expired Job should be released: %s", *lease.Spec.HolderIdentity)
+	}
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+)
+
+// ParameterError lists the options that don't satisfy the parameters of an
+// action.
+type ParameterError struct {
+	Problems []string
+}
+
+func (e *ParameterError) Error() string {
+	return "invalid options: " + strings.Join(e.Problems, "; ")
+}
+
+// parameterPatterns caches the compiled patterns of the parameters, which are
+// otherwise compiled for every invocation.
+var parameterPatterns sync.Map
+
+// compileParameterPattern compiles the pattern of a parameter so that it
+// matches the whole value.
+func compileParameterPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := parameterPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	parameterPatterns.Store(pattern, re)
+	return re, nil
+}
+
+// ValidateParameters validates the options of a command against the
+// parameters of the action, and returns the options with the defaults
+// applied. The values are normalized to the types decoded from JSON:
+// strings, float64 for Integer and Number, and bool. Values given as strings,
+// such as by InteractionRuns, are parsed. If no parameters are declared, the
+// options are returned as they are. A *ParameterError is returned if any
+// option is invalid.
+func ValidateParameters(
+	params []vahkanev1.DiscordInteractionActionParameter,
+	options map[string]interface{},
+) (map[string]interface{}, error) {
+	if len(params) == 0 {
+		return options, nil
+	}
+
+	var problems []string
+	validated := map[string]interface{}{}
+	for i := range params {
+		param := &params[i]
+		value, ok := options[param.Name]
+		if !ok && param.Default != nil {
+			value, ok = *param.Default, true
+		}
+		if !ok {
+			if param.Required {
+				problems = append(problems, fmt.Sprintf("option `%s` is required", param.Name))
+			}
+			continue
+		}
+		normalized, err := validateParameter(param, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("option `%s` %v", param.Name, err))
+			continue
+		}
+		validated[param.Name] = normalized
+	}
+
+	var unknown []string
+	for name := range options {
+		if !slices.ContainsFunc(params, func(p vahkanev1.DiscordInteractionActionParameter) bool {
+			return p.Name == name
+		}) {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("option `%s` is unknown", name))
+	}
+
+	if len(problems) > 0 {
+		return nil, &ParameterError{Problems: problems}
+	}
+	return validated, nil
+}
+
+// validateParameter returns the normalized value if it satisfies the
+// parameter. The error describes the problem following the option name.
+func validateParameter(
+	param *vahkanev1.DiscordInteractionActionParameter,
+	value interface{},
+) (interface{}, error) {
+	var normalized interface{}
+	var text string
+
+	switch param.Type {
+	case vahkanev1.ActionParameterTypeInteger, vahkanev1.ActionParameterTypeNumber:
+		var number float64
+		switch value := value.(type) {
+		case float64:
+			number = value
+		case string:
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errors.New("must be a number")
+			}
+			number = parsed
+		default:
+			return nil, errors.New("must be a number")
+		}
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, errors.New("must be a number")
+		}
+		if param.Type == vahkanev1.ActionParameterTypeInteger && number != math.Trunc(number) {
+			return nil, errors.New("must be an integer")
+		}
+		if param.Min != nil && number < float64(*param.Min) {
+			return nil, fmt.Errorf("must be at least %d", *param.Min)
+		}
+		if param.Max != nil && number > float64(*param.Max) {
+			return nil, fmt.Errorf("must be at most %d", *param.Max)
+		}
+		normalized, text = number, strconv.FormatFloat(number, 'f', -1, 64)
+
+	case vahkanev1.ActionParameterTypeBoolean:
+		var b bool
+		switch value := value.(type) {
+		case bool:
+			b = value
+		case string:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.New("must be true or false")
+			}
+			b = parsed
+		default:
+			return nil, errors.New("must be true or false")
+		}
+		normalized, text = b, strconv.FormatBool(b)
+
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+			return nil, errors.New("must not contain control characters")
+		}
+		length := int64(utf8.RuneCountInString(s))
+		if param.Min != nil && length < *param.Min {
+			return nil, fmt.Errorf("must be at least %d characters", *param.Min)
+		}
+		if param.Max != nil && length > *param.Max {
+			return nil, fmt.Errorf("must be at most %d characters", *param.Max)
+		}
+		normalized, text = s, s
+	}
+
+	if len(param.Enum) > 0 && !slices.Contains(param.Enum, text) {
+		return nil, fmt.Errorf("must be one of %s", strings.Join(param.Enum, ", "))
+	}
+	if param.Pattern != "" {
+		re, err := compileParameterPattern(param.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("can't be validated: invalid pattern: %w", err)
+		}
+		if !re.MatchString(text) {
+			return nil, fmt.Errorf("must match %s", param.Pattern)
+		}
+	}
+	return normalized, nil
+}
+
+// OptionStrings returns the values of the options as strings, such as for
+// InteractionRunSpec.Options.
+func OptionStrings(options map[string]interface{}) map[string]string {
+	values := make(map[string]string, len(options))
+	for name, value := range options {
+		switch value := value.(type) {
+		case float64:
+			values[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestValidateParameters(t *testing.T) {
+	params := []vahkanev1.DiscordInteractionActionParameter{
+		{Name: "env", Required: true, Enum: []string{"staging", "prod"}},
+		{Name: "ref", Default: ptr.To("main"), Pattern: `[a-z0-9./-]+`, Max: ptr.To[int64](20)},
+		{Name: "replicas", Type: vahkanev1.ActionParameterTypeInteger, Min: ptr.To[int64](0), Max: ptr.To[int64](5)},
+		{Name: "ratio", Type: vahkanev1.ActionParameterTypeNumber},
+		{Name: "dry-run", Type: vahkanev1.ActionParameterTypeBoolean, Default: ptr.To("false")},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		options  map[string]interface{}
+		expected map[string]interface{}
+		problems []string
+	}{
+		{
+			name:    "valid options from Discord",
+			options: map[string]interface{}{"env": "prod", "replicas": float64(3), "ratio": 0.5, "dry-run": true},
+			expected: map[string]interface{}{
+				"env": "prod", "ref": "main", "replicas": float64(3), "ratio": 0.5, "dry-run": true,
+			},
+		},
+		{
+			name:    "valid options as strings",
+			options: map[string]interface{}{"env": "staging", "ref": "v1.2.3", "replicas": "0", "dry-run": "true"},
+			expected: map[string]interface{}{
+				"env": "staging", "ref": "v1.2.3", "replicas": float64(0), "dry-run": true,
+			},
+		},
+		{
+			name:     "missing required option",
+			options:  map[string]interface{}{},
+			problems: []string{"option `env` is required"},
+		},
+		{
+			name: "invalid values",
+			options: map[string]interface{}{
+				"env":      "dev",
+				"ref":      "main; rm -rf /",
+				"replicas": float64(1.5),
+				"ratio":    "half",
+				"dry-run":  "maybe",
+				"force":    true,
+			},
+			problems: []string{
+				"option `env` must be one of staging, prod",
+				"option `ref` must match [a-z0-9./-]+",
+				"option `replicas` must be an integer",
+				"option `ratio` must be a number",
+				"option `dry-run` must be true or false",
+				"option `force` is unknown",
+			},
+		},
+		{
+			name:     "out of range",
+			options:  map[string]interface{}{"env": "prod", "ref": "a-very-long-branch-name", "replicas": float64(6)},
+			problems: []string{"option `ref` must be at most 20 characters", "option `replicas` must be at most 5"},
+		},
+		{
+			name:     "control characters",
+			options:  map[string]interface{}{"env": "prod\n"},
+			problems: []string{"option `env` must not contain control characters"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			validated, err := ValidateParameters(params, tt.options)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(validated, tt.expected) {
+					t.Errorf("unexpected options: %v", validated)
+				}
+				return
+			}
+			var paramErr *ParameterError
+			if !errors.As(err, &paramErr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(paramErr.Problems, tt.problems) {
+				t.Errorf("unexpected problems: %q", paramErr.Problems)
+			}
+		})
+	}
+
+	// Options are not validated without parameters.
+	options := map[string]interface{}{"anything": "goes\n"}
+	if validated, err := ValidateParameters(nil, options); err != nil || !reflect.DeepEqual(validated, options) {
+		t.Errorf("unexpected result: %v: %v", validated, err)
+	}
+}
+
+func TestOptionStrings(t *testing.T) {
+	values := OptionStrings(map[string]interface{}{"n": float64(1000000), "b": true, "s": "x"})
+	if !reflect.DeepEqual(values, map[string]string{"n": "1000000", "b": "true", "s": "x"}) {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// countingFollowupClient counts the follow-up messages.
+type countingFollowupClient struct {
+	discord.Client
+	sent int
+}
+
+func (c *countingFollowupClient) SendFollowupMessage(context.Context, string, string) error {
+	c.sent++
+	return nil
+}
+
+func TestReconcileJobReportsOnce(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "report-once", Namespace: "ns"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{Name: "action"}},
+		},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "job",
+			Namespace: "ns",
+			Labels:    map[string]string{LabelKeyJob: "true"},
+			Annotations: map[string]string{
+				AnnotKeyDiscordInteraction:      di.GetName(),
+				AnnotKeyAction:                  "action",
+				AnnotKeyDiscordInteractionToken: "token",
+			},
+		},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+		}},
+	}
+
+	// The first deletion of the reported Job fails.
+	deleteFailed := false
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, job).
+		WithStatusSubresource(di).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if _, ok := obj.(*batchv1.Job); ok && !deleteFailed {
+					deleteFailed = true
+					return errors.New("unavailable")
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).
+		Build()
+
+	discordClient := &countingFollowupClient{}
+	var auditLog bytes.Buffer
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewJobReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, nil),
+		nil,
+		recorder,
+		audit.NewWriterSink(&auditLog),
+	)
+	finished := metrics.JobsFinishedTotal.WithLabelValues(di.GetName(), "action", string(vahkanev1.RunResultSucceeded))
+	before := testutil.ToFloat64(finished)
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(job)}
+	if _, err := reconciler.Reconcile(ctx, req); err == nil {
+		t.Fatal("the first reconciliation should fail to delete the Job")
+	}
+	if _, err := reconciler.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(job), job); !k8serrors.IsNotFound(err) {
+		t.Errorf("the Job should be deleted: %v", err)
+	}
+	if discordClient.sent != 1 {
+		t.Errorf("the result should be sent once: %d", discordClient.sent)
+	}
+	if lines := bytes.Count(auditLog.Bytes(), []byte("\n")); lines != 1 {
+		t.Errorf("the audit entry should be written once: %s", auditLog.String())
+	}
+	if got := testutil.ToFloat64(finished) - before; got != 1 {
+		t.Errorf("the finished Job should be counted once: %v", got)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonFollowupSent)
+}
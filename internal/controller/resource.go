@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// resourcePollInterval is the interval to check whether the objects created
+// by resource actions have finished. They are polled because their kinds are
+// not known until DiscordInteractions are created.
+const resourcePollInterval = 10 * time.Second
+
+// ResourceTemplate returns the template of the object created by the
+// resource action.
+func ResourceTemplate(resource *vahkanev1.DiscordInteractionActionResource) (*unstructured.Unstructured, error) {
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(resource.Template.Raw, &obj.Object); err != nil {
+		return nil, fmt.Errorf("failed to parse the resource template: %w", err)
+	}
+	if obj.GetAPIVersion() == "" || obj.GetKind() == "" {
+		return nil, fmt.Errorf("apiVersion and kind are required in the resource template")
+	}
+	return &obj, nil
+}
+
+// ListRunResources lists the objects of the resource action that match the
+// labels in the namespace.
+func ListRunResources(
+	ctx context.Context,
+	k8sClient client.Reader,
+	resource *vahkanev1.DiscordInteractionActionResource,
+	namespace string,
+	labels map[string]string,
+) ([]unstructured.Unstructured, error) {
+	template, err := ResourceTemplate(resource)
+	if err != nil {
+		return nil, err
+	}
+	var list unstructured.UnstructuredList
+	list.SetAPIVersion(template.GetAPIVersion())
+	list.SetKind(template.GetKind() + "List")
+	if err := k8sClient.List(
+		ctx,
+		&list,
+		client.InNamespace(namespace),
+		client.MatchingLabels(labels),
+	); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", template.GetKind(), err)
+	}
+	return list.Items, nil
+}
+
+// ResourceRunResult returns whether the object has finished and its result
+// according to the completion.
+func ResourceRunResult(
+	obj *unstructured.Unstructured,
+	completion *vahkanev1.DiscordInteractionActionResourceCompletion,
+) (bool, vahkanev1.RunResult) {
+	if completion.ConditionType != "" {
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, ok := c.(map[string]interface{})
+			if !ok || cond["type"] != completion.ConditionType {
+				continue
+			}
+			switch cond["status"] {
+			case string(corev1.ConditionTrue):
+				return true, vahkanev1.RunResultSucceeded
+			case string(corev1.ConditionFalse):
+				return true, vahkanev1.RunResultFailed
+			}
+		}
+		return false, ""
+	}
+
+	value, found, err := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(completion.FieldPath, ".")...)
+	if err != nil || !found {
+		return false, ""
+	}
+	str := fmt.Sprint(value)
+	if slices.Contains(completion.SuccessValues, str) {
+		return true, vahkanev1.RunResultSucceeded
+	}
+	if slices.Contains(completion.FailureValues, str) {
+		return true, vahkanev1.RunResultFailed
+	}
+	return false, ""
+}
+
+// ResourceReconciler reports the results of the objects created by the
+// resource actions of DiscordInteractions in the same way as JobReconciler.
+type ResourceReconciler struct {
+	Client       client.Client
+	Scheme       *runtime.Scheme
+	apiReader    client.Reader
+	applications *ApplicationResolver
+	recorder     record.EventRecorder
+	audit        audit.Sink
+}
+
+func NewResourceReconciler(
+	client client.Client,
+	apiReader client.Reader,
+	scheme *runtime.Scheme,
+	applications *ApplicationResolver,
+	recorder record.EventRecorder,
+	auditSink audit.Sink,
+) *ResourceReconciler {
+	if auditSink == nil {
+		auditSink = audit.Discard
+	}
+	return &ResourceReconciler{
+		Client:       client,
+		Scheme:       scheme,
+		apiReader:    apiReader,
+		applications: applications,
+		recorder:     recorder,
+		audit:        auditSink,
+	}
+}
+
+func (r *ResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var di vahkanev1.DiscordInteraction
+	if err := r.Client.Get(ctx, req.NamespacedName, &di); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	hasResourceActions := false
+	for i := range di.Spec.Actions {
+		action := &di.Spec.Actions[i]
+		if action.ActionInline.Resource == nil {
+			continue
+		}
+		hasResourceActions = true
+		if err := r.reconcileAction(ctx, &di, action); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !hasResourceActions {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: resourcePollInterval}, nil
+}
+
+func (r *ResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&vahkanev1.DiscordInteraction{}).
+		Named("resource").
+		Complete(r)
+}
+
+func (r *ResourceReconciler) reconcileAction(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) error {
+	objs, err := ListRunResources(
+		ctx,
+		r.Client,
+		action.ActionInline.Resource,
+		di.GetNamespace(),
+		map[string]string{LabelKeyJob: "true"},
+	)
+	if err != nil {
+		return err
+	}
+
+	reported := false
+	for i := range objs {
+		obj := &objs[i]
+		annots := obj.GetAnnotations()
+		if annots[AnnotKeyDiscordInteraction] != di.GetName() || annots[AnnotKeyAction] != action.Name {
+			continue
+		}
+		finished, result := ResourceRunResult(obj, &action.ActionInline.Resource.Completion)
+		if !finished || !obj.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		if _, ok := annots[annotKeyReported]; !ok {
+			if err := r.reportResource(ctx, di, action, obj, result); err != nil {
+				return err
+			}
+			reported = true
+		}
+		if action.Retention == nil {
+			propagationPolicy := metav1.DeletePropagationBackground
+			if err := r.Client.Delete(ctx, obj, &client.DeleteOptions{
+				PropagationPolicy: &propagationPolicy,
+			}); err != nil && !k8serrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s: %w", obj.GetKind(), err)
+			}
+		}
+	}
+
+	if reported && action.Retention != nil {
+		if err := r.pruneResources(ctx, di, action, objs); err != nil {
+			return fmt.Errorf("failed to prune objects: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *ResourceReconciler) reportResource(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	obj *unstructured.Unstructured,
+	result vahkanev1.RunResult,
+) error {
+	logger := log.FromContext(ctx)
+
+	creationTimestamp := obj.GetCreationTimestamp()
+	if err := recordRun(ctx, r.Client, di, obj, result, &creationTimestamp, nil); err != nil {
+		return fmt.Errorf("failed to record the run: %w", err)
+	}
+	if err := UpdateInteractionRunPhase(ctx, r.Client, r.apiReader, obj, InteractionRunPhaseOf(result)); err != nil {
+		return fmt.Errorf("failed to update InteractionRun: %w", err)
+	}
+	if err := ReleaseJobLock(ctx, r.Client, r.apiReader, obj); err != nil {
+		return fmt.Errorf("failed to release the job lock: %w", err)
+	}
+
+	// The object is marked before it is reported so that a retried
+	// reconciliation doesn't report it again.
+	annots := obj.GetAnnotations()
+	annots[annotKeyReported] = "true"
+	obj.SetAnnotations(annots)
+	if err := r.Client.Update(ctx, obj); err != nil {
+		return fmt.Errorf("failed to mark %s as reported: %w", obj.GetKind(), err)
+	}
+
+	metrics.JobsFinishedTotal.WithLabelValues(di.GetName(), action.Name, string(result)).Inc()
+	writeRunAudit(ctx, r.audit, obj, result)
+
+	msg := "completed"
+	if result == vahkanev1.RunResultFailed {
+		msg = "failed"
+	}
+	app, err := r.applications.Resolve(ctx, JobApplicationKey(obj))
+	if err == nil {
+		err = sendRunMessage(ctx, app.Client, obj, msg, IsResultEphemeral(action, result))
+	}
+	if err != nil {
+		logger.Error(err, "failed to send followup messages")
+		r.recorder.Eventf(obj, corev1.EventTypeWarning, eventReasonFollowupFailed,
+			"Failed to send the result to Discord: %v", err)
+	} else {
+		r.recorder.Eventf(obj, corev1.EventTypeNormal, eventReasonFollowupSent,
+			"Sent the result to Discord: %s", msg)
+	}
+	return nil
+}
+
+// pruneResources deletes the reported objects of the action that exceed the
+// history limits of the retention. The objects are ordered by their creation
+// time because their completion time is not known in general.
+func (r *ResourceReconciler) pruneResources(
+	ctx context.Context,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	objs []unstructured.Unstructured,
+) error {
+	var succeeded, failed []*unstructured.Unstructured
+	for i := range objs {
+		obj := &objs[i]
+		annots := obj.GetAnnotations()
+		if annots[AnnotKeyDiscordInteraction] != di.GetName() || annots[AnnotKeyAction] != action.Name {
+			continue
+		}
+		if _, ok := annots[annotKeyReported]; !ok {
+			continue
+		}
+		finished, result := ResourceRunResult(obj, &action.ActionInline.Resource.Completion)
+		if !finished {
+			continue
+		}
+		if result == vahkanev1.RunResultSucceeded {
+			succeeded = append(succeeded, obj)
+		} else {
+			failed = append(failed, obj)
+		}
+	}
+
+	for _, e := range []struct {
+		objs  []*unstructured.Unstructured
+		limit *int32
+	}{
+		{objs: succeeded, limit: action.Retention.SuccessfulJobsHistoryLimit},
+		{objs: failed, limit: action.Retention.FailedJobsHistoryLimit},
+	} {
+		if e.limit == nil || int32(len(e.objs)) <= *e.limit {
+			continue
+		}
+		sort.Slice(e.objs, func(i, j int) bool {
+			ti, tj := e.objs[i].GetCreationTimestamp(), e.objs[j].GetCreationTimestamp()
+			return tj.Before(&ti)
+		})
+		propagationPolicy := metav1.DeletePropagationBackground
+		for _, obj := range e.objs[*e.limit:] {
+			if err := r.Client.Delete(ctx, obj, &client.DeleteOptions{
+				PropagationPolicy: &propagationPolicy,
+			}); err != nil && !k8serrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s: %s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// getRunState returns whether the object of the run exists and whether it
+// has finished.
+func getRunState(
+	ctx context.Context,
+	k8sClient client.Reader,
+	action *vahkanev1.DiscordInteractionAction,
+	name types.NamespacedName,
+) (bool, bool, error) {
+	if action.ActionInline.Resource == nil {
+		var job batchv1.Job
+		if err := k8sClient.Get(ctx, name, &job); err != nil {
+			if k8serrors.IsNotFound(err) {
+				return false, false, nil
+			}
+			return false, false, fmt.Errorf("failed to get Job: %w", err)
+		}
+		return true, IsJobFinished(&job) || !job.GetDeletionTimestamp().IsZero(), nil
+	}
+
+	template, err := ResourceTemplate(action.ActionInline.Resource)
+	if err != nil {
+		return false, false, err
+	}
+	var obj unstructured.Unstructured
+	obj.SetGroupVersionKind(template.GroupVersionKind())
+	if err := k8sClient.Get(ctx, name, &obj); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to get %s: %w", template.GetKind(), err)
+	}
+	finished, _ := ResourceRunResult(&obj, &action.ActionInline.Resource.Completion)
+	return true, finished || !obj.GetDeletionTimestamp().IsZero(), nil
+}
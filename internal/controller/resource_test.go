@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	discord "github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+type followupClient struct {
+	discord.Client
+	messages map[string]string
+}
+
+func (c *followupClient) SendFollowupMessage(_ context.Context, interactionToken, message string) error {
+	c.messages[interactionToken] = message
+	return nil
+}
+
+func TestResourceRunResult(t *testing.T) {
+	pipelineRun := func(status string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Succeeded", "status": status},
+				},
+			},
+		}}
+	}
+	workflow := func(phase string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{"phase": phase},
+		}}
+	}
+	byCondition := &vahkanev1.DiscordInteractionActionResourceCompletion{ConditionType: "Succeeded"}
+	byField := &vahkanev1.DiscordInteractionActionResourceCompletion{
+		FieldPath:     "status.phase",
+		SuccessValues: []string{"Succeeded"},
+		FailureValues: []string{"Failed", "Error"},
+	}
+
+	tests := []struct {
+		name       string
+		obj        *unstructured.Unstructured
+		completion *vahkanev1.DiscordInteractionActionResourceCompletion
+		finished   bool
+		result     vahkanev1.RunResult
+	}{
+		{"condition true", pipelineRun("True"), byCondition, true, vahkanev1.RunResultSucceeded},
+		{"condition false", pipelineRun("False"), byCondition, true, vahkanev1.RunResultFailed},
+		{"condition unknown", pipelineRun("Unknown"), byCondition, false, ""},
+		{"no condition", &unstructured.Unstructured{Object: map[string]interface{}{}}, byCondition, false, ""},
+		{"field success", workflow("Succeeded"), byField, true, vahkanev1.RunResultSucceeded},
+		{"field failure", workflow("Error"), byField, true, vahkanev1.RunResultFailed},
+		{"field running", workflow("Running"), byField, false, ""},
+		{"no field", &unstructured.Unstructured{Object: map[string]interface{}{}}, byField, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finished, result := ResourceRunResult(tt.obj, tt.completion)
+			if finished != tt.finished || result != tt.result {
+				t.Errorf("ResourceRunResult returns (%v, %s)", finished, result)
+			}
+		})
+	}
+}
+
+func TestResourceReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	// Use ConfigMaps as the objects of the resource action so that the fake
+	// client knows their kind.
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					Resource: &vahkanev1.DiscordInteractionActionResource{
+						Template: runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap"}`)},
+						Completion: vahkanev1.DiscordInteractionActionResourceCompletion{
+							FieldPath:     "data.state",
+							SuccessValues: []string{"done"},
+						},
+					},
+				},
+			}},
+		},
+	}
+	newConfigMap := func(name, state string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns",
+				Labels:    map[string]string{LabelKeyJob: "true", LabelKeyJobGroup: "group"},
+				Annotations: map[string]string{
+					AnnotKeyDiscordInteraction:      "di",
+					AnnotKeyAction:                  "action",
+					AnnotKeyDiscordInteractionToken: name + "-token",
+				},
+			},
+			Data: map[string]string{"state": state},
+		}
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, newConfigMap("done", "done"), newConfigMap("running", "running")).
+		WithStatusSubresource(di).
+		Build()
+
+	discordClient := &followupClient{messages: map[string]string{}}
+	var auditLog bytes.Buffer
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewResourceReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, nil),
+		recorder,
+		audit.NewWriterSink(&auditLog),
+	)
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+		Name: "di", Namespace: "ns",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("the DiscordInteraction should be polled")
+	}
+
+	if msg := discordClient.messages["done-token"]; msg != "completed" {
+		t.Errorf("unexpected followup message: %s", msg)
+	}
+	if _, ok := discordClient.messages["running-token"]; ok {
+		t.Error("the running object should not be reported")
+	}
+
+	var cm corev1.ConfigMap
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "done", Namespace: "ns"}, &cm); !k8serrors.IsNotFound(err) {
+		t.Errorf("the finished object should be deleted: %v", err)
+	}
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "running", Namespace: "ns"}, &cm); err != nil {
+		t.Errorf("the running object should be kept: %v", err)
+	}
+
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "di", Namespace: "ns"}, di); err != nil {
+		t.Fatal(err)
+	}
+	if len(di.Status.History) != 1 || di.Status.History[0].JobName != "done" ||
+		di.Status.History[0].Result != vahkanev1.RunResultSucceeded {
+		t.Errorf("unexpected history: %+v", di.Status.History)
+	}
+
+	var entry audit.Entry
+	if err := json.Unmarshal(auditLog.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Decision != audit.DecisionFinished || entry.JobName != "done" ||
+		entry.Result != string(vahkanev1.RunResultSucceeded) {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonFollowupSent)
+}
+
+func TestResourceReconcilerReportsOnce(t *testing.T) {
+	ctx := context.Background()
+
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					Resource: &vahkanev1.DiscordInteractionActionResource{
+						Template: runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap"}`)},
+						Completion: vahkanev1.DiscordInteractionActionResourceCompletion{
+							FieldPath:     "data.state",
+							SuccessValues: []string{"done"},
+						},
+					},
+				},
+			}},
+		},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "done",
+			Namespace: "ns",
+			Labels:    map[string]string{LabelKeyJob: "true", LabelKeyJobGroup: "group"},
+			Annotations: map[string]string{
+				AnnotKeyDiscordInteraction:      "di",
+				AnnotKeyAction:                  "action",
+				AnnotKeyDiscordInteractionToken: "token",
+			},
+		},
+		Data: map[string]string{"state": "done"},
+	}
+
+	// The first deletion of the reported object fails.
+	deleteFailed := false
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, cm).
+		WithStatusSubresource(di).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if !deleteFailed {
+					deleteFailed = true
+					return errors.New("unavailable")
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).
+		Build()
+
+	discordClient := &followupClient{messages: map[string]string{}}
+	var auditLog bytes.Buffer
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewResourceReconciler(
+		k8sClient,
+		k8sClient,
+		k8sClient.Scheme(),
+		NewApplicationResolver(k8sClient, &Application{Client: discordClient}, types.NamespacedName{}, nil),
+		recorder,
+		audit.NewWriterSink(&auditLog),
+	)
+	finished := metrics.JobsFinishedTotal.WithLabelValues("di", "action", string(vahkanev1.RunResultSucceeded))
+	before := testutil.ToFloat64(finished)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(di)}
+	if _, err := reconciler.Reconcile(ctx, req); err == nil {
+		t.Fatal("the first reconciliation should fail to delete the object")
+	}
+	delete(discordClient.messages, "token")
+	if _, err := reconciler.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), cm); !k8serrors.IsNotFound(err) {
+		t.Errorf("the object should be deleted: %v", err)
+	}
+	if _, ok := discordClient.messages["token"]; ok {
+		t.Error("the result should not be sent again")
+	}
+	if lines := bytes.Count(auditLog.Bytes(), []byte("\n")); lines != 1 {
+		t.Errorf("the audit entry should be written once: %s", auditLog.String())
+	}
+	if got := testutil.ToFloat64(finished) - before; got != 1 {
+		t.Errorf("the finished object should be counted once: %v", got)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonFollowupSent)
+}
@@ -0,0 +1,513 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// AnnotKeyInteractionRun is the name of the InteractionRun of the run.
+	AnnotKeyInteractionRun = "vahkane.anqou.net/interaction-run"
+	// AnnotKeyReportChannelID is the ID of the channel where the result of
+	// a run without an interaction token is posted.
+	AnnotKeyReportChannelID = "vahkane.anqou.net/report-channel-id"
+)
+
+// ErrTooManyJobs is returned by StartRun if the DiscordInteraction has reached
+// MaxConcurrentJobs and its ConcurrencyLimitPolicy is Refuse.
+var ErrTooManyJobs = errors.New("too many jobs are running")
+
+// RunInvocation describes who triggered a run and how.
+type RunInvocation struct {
+	// InteractionID identifies the run. Runs with the same InteractionID
+	// are created only once.
+	InteractionID string
+	// Token is the token of the interaction to follow up. It is empty for
+	// runs created by hand.
+	Token string
+	// ChannelID is the channel where the result is posted if Token is
+	// empty.
+	ChannelID string
+	UserID    string
+	UserName  string
+	Options   map[string]string
+	// RunName is the name of the InteractionRun that triggered the run. If
+	// it is empty, an InteractionRun is created as the record of the run.
+	RunName string
+}
+
+// StartRun creates the Job, or the object of a resource action, for the run of
+// the action. It returns the name of the created object and whether it is
+// queued because of MaxConcurrentJobs. ErrAlreadyRunning and ErrTooManyJobs
+// are returned if the run is refused. ErrDuplicateInteraction is returned with
+// the name of the object if the run has already been started, e.g., by a
+// retried reconcile. apiReader reads the objects bypassing the cache, which
+// may not have the object created by the previous attempt yet.
+func StartRun(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	inv *RunInvocation,
+) (string, bool, error) {
+	jobGroup := JobGroupName(di.Name, action)
+	runName := RunJobName(jobGroup, inv.InteractionID)
+
+	// The run must not be refused by the limit or the lock that it counts
+	// toward or holds itself.
+	exists, queued, err := lookupRun(
+		ctx, apiReader, action, types.NamespacedName{Name: runName, Namespace: di.Namespace})
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return runName, queued, ErrDuplicateInteraction
+	}
+
+	// The lock is released if the run is refused, so that the next run
+	// doesn't wait for the grace period of the lock.
+	started := false
+	if !action.Concurrent {
+		if err := acquireActionLock(ctx, k8sClient, apiReader, di, action, jobGroup, runName); err != nil {
+			return "", false, err
+		}
+		defer func() {
+			if started {
+				return
+			}
+			if err := ReleaseJobLock(ctx, k8sClient, apiReader, newRunLockHolder(di.Namespace, jobGroup, runName)); err != nil {
+				log.FromContext(ctx).Error(err, "failed to release the job lock", "job", runName)
+			}
+		}()
+	}
+
+	if limitsConcurrency(di, action) {
+		// The Jobs must not be started or resumed by others until the Job
+		// of the run is created.
+		unlock, err := lockConcurrency(ctx, k8sClient, apiReader, di, runName)
+		if err != nil {
+			return "", false, err
+		}
+		defer unlock()
+	}
+	queued, err = checkConcurrencyLimit(ctx, apiReader, di, action)
+	if err != nil {
+		if errors.Is(err, ErrTooManyJobs) {
+			return "", false, err
+		}
+		return "", false, fmt.Errorf("failed to check the concurrency limit: %w", err)
+	}
+
+	jobName, err := createRunObject(ctx, k8sClient, di, action, inv, queued)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateInteraction) {
+			// Another attempt has created the object and holds the lock.
+			started = true
+			return runName, queued, err
+		}
+		return "", false, err
+	}
+	started = true
+
+	if inv.RunName == "" {
+		// The run has started, so failing to record it is not fatal.
+		if err := createInteractionRun(ctx, k8sClient, di, action, inv, jobName, queued); err != nil {
+			log.FromContext(ctx).Error(err, "failed to create InteractionRun", "job", jobName)
+		}
+	}
+
+	return jobName, queued, nil
+}
+
+// acquireActionLock makes the run the only one in progress of the action.
+// The lock is already held by the run if the previous attempt failed to
+// create the object. The object is created only once whichever attempt
+// creates it. The lock is not held if an error is returned.
+func acquireActionLock(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	jobGroup, runName string,
+) error {
+	err := AcquireJobLock(ctx, k8sClient, apiReader, di, action, jobGroup, runName)
+	if err != nil && !errors.Is(err, ErrDuplicateInteraction) {
+		return err
+	}
+
+	// Only the runs that hold the lock check the runs in progress, so that
+	// two runs checking at once can't both miss each other. The runs created
+	// without the lock are found here too.
+	running, err := isActionRunning(ctx, apiReader, action, di.Name, di.Namespace)
+	if err == nil && !running {
+		return nil
+	}
+	if err := ReleaseJobLock(ctx, k8sClient, apiReader, newRunLockHolder(di.Namespace, jobGroup, runName)); err != nil {
+		log.FromContext(ctx).Error(err, "failed to release the job lock", "job", runName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check if Job already exists: %w", err)
+	}
+	return ErrAlreadyRunning
+}
+
+// lookupRun returns whether the object of the run exists and whether it is
+// queued.
+func lookupRun(
+	ctx context.Context,
+	apiReader client.Reader,
+	action *vahkanev1.DiscordInteractionAction,
+	name types.NamespacedName,
+) (bool, bool, error) {
+	var obj metav1.PartialObjectMetadata
+	if resource := action.ActionInline.Resource; resource != nil {
+		template, err := ResourceTemplate(resource)
+		if err != nil {
+			return false, false, err
+		}
+		obj.SetGroupVersionKind(template.GroupVersionKind())
+	} else {
+		obj.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+	}
+	if err := apiReader.Get(ctx, name, &obj); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to get %s: %w", obj.Kind, err)
+	}
+	_, queued := obj.GetAnnotations()[AnnotKeyQueued]
+	return true, queued, nil
+}
+
+// limitsConcurrency reports whether the runs of the action count against
+// MaxConcurrentJobs of the DiscordInteraction.
+func limitsConcurrency(di *vahkanev1.DiscordInteraction, action *vahkanev1.DiscordInteractionAction) bool {
+	return di.Spec.MaxConcurrentJobs != nil && action.ActionInline.Resource == nil
+}
+
+// checkConcurrencyLimit checks whether the run of the action exceeds
+// MaxConcurrentJobs of the DiscordInteraction. It returns true if the Job
+// should be queued, or ErrTooManyJobs if the run should be refused. The Jobs
+// are counted by apiReader, since the cache may not have the Jobs just
+// created by other replicas.
+func checkConcurrencyLimit(
+	ctx context.Context,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) (bool, error) {
+	if !limitsConcurrency(di, action) {
+		return false, nil
+	}
+	running, queued, err := ListDiscordInteractionJobs(ctx, apiReader, di)
+	if err != nil {
+		return false, err
+	}
+	// Jobs queued earlier start first.
+	if len(running) < int(*di.Spec.MaxConcurrentJobs) && len(queued) == 0 {
+		return false, nil
+	}
+	if di.Spec.ConcurrencyLimitPolicy == vahkanev1.ConcurrencyLimitPolicyQueue {
+		return true, nil
+	}
+	return false, ErrTooManyJobs
+}
+
+// newRunLockHolder returns the object standing for the run in ReleaseJobLock
+// before the object of the run is created.
+func newRunLockHolder(namespace, jobGroup, runName string) metav1.Object {
+	return &metav1.ObjectMeta{
+		Name:      runName,
+		Namespace: namespace,
+		Labels:    map[string]string{LabelKeyJobGroup: jobGroup},
+	}
+}
+
+// isActionRunning reports whether an unfinished Job, or an unfinished object
+// of a resource action, of the action exists.
+func isActionRunning(
+	ctx context.Context,
+	k8sClient client.Reader,
+	action *vahkanev1.DiscordInteractionAction,
+	diName, namespace string,
+) (bool, error) {
+	groupLabels := map[string]string{LabelKeyJobGroup: JobGroupName(diName, action)}
+
+	if resource := action.ActionInline.Resource; resource != nil {
+		objs, err := ListRunResources(ctx, k8sClient, resource, namespace, groupLabels)
+		if err != nil {
+			return false, err
+		}
+		for i := range objs {
+			if finished, _ := ResourceRunResult(&objs[i], &resource.Completion); !finished {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	var jobList batchv1.JobList
+	if err := k8sClient.List(
+		ctx,
+		&jobList,
+		client.InNamespace(namespace),
+		client.MatchingLabels(groupLabels),
+	); err != nil {
+		return false, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+	for _, job := range jobList.Items {
+		if !IsJobFinished(&job) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newJobFromTemplate returns the Job of the run created from the template.
+func newJobFromTemplate(
+	action *vahkanev1.DiscordInteractionAction,
+	template *batchv1.JobTemplateSpec,
+) *batchv1.Job {
+	var job batchv1.Job
+	job.Spec = template.Spec
+	job.ObjectMeta = template.ObjectMeta
+	job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if action.Retention != nil && job.Spec.TTLSecondsAfterFinished == nil {
+		job.Spec.TTLSecondsAfterFinished = action.Retention.TTLSecondsAfterFinished
+	}
+	return &job
+}
+
+// createRunObject creates the Job of the run, or the object of the run if the
+// action is a resource action.
+func createRunObject(
+	ctx context.Context,
+	k8sClient client.Client,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	inv *RunInvocation,
+	queued bool,
+) (string, error) {
+	var job client.Object
+
+	jobName := JobGroupName(di.Name, action)
+
+	switch {
+	case action.ActionInline.Resource != nil:
+		obj, err := ResourceTemplate(action.ActionInline.Resource)
+		if err != nil {
+			return "", err
+		}
+		job = obj
+
+	case action.ActionInline.JobTemplate != nil:
+		job = newJobFromTemplate(action, action.ActionInline.JobTemplate)
+
+	case action.ActionInline.CronJobRef != nil:
+		var cronJob batchv1.CronJob
+		if err := k8sClient.Get(
+			ctx,
+			types.NamespacedName{Name: action.ActionInline.CronJobRef.Name, Namespace: di.Namespace},
+			&cronJob,
+		); err != nil {
+			return "", fmt.Errorf("failed to get CronJob: %w", err)
+		}
+		batchJob := newJobFromTemplate(action, cronJob.Spec.JobTemplate.DeepCopy())
+		// Mark the Job as created manually like "kubectl create job --from".
+		// The owner reference is not a controller one so that the CronJob
+		// controller doesn't prune the Job before its result is reported.
+		annots := batchJob.GetAnnotations()
+		if annots == nil {
+			annots = map[string]string{}
+		}
+		annots["cronjob.kubernetes.io/instantiate"] = "manual"
+		batchJob.SetAnnotations(annots)
+		batchJob.SetNamespace(di.Namespace)
+		if err := controllerutil.SetOwnerReference(&cronJob, batchJob, k8sClient.Scheme()); err != nil {
+			return "", fmt.Errorf("failed to set owner reference: %w", err)
+		}
+		job = batchJob
+
+	default:
+		return "", fmt.Errorf("no template in action: %s", action.Name)
+	}
+	if batchJob, ok := job.(*batchv1.Job); ok && queued {
+		// The Job is resumed by JobQueueReconciler.
+		suspend := true
+		batchJob.Spec.Suspend = &suspend
+	}
+	job.SetNamespace(di.Namespace)
+	job.SetName(RunJobName(jobName, inv.InteractionID))
+	// The object is garbage-collected with the DiscordInteraction, whose
+	// finalizer cancels the runs in progress first.
+	if err := controllerutil.SetOwnerReference(di, job, k8sClient.Scheme()); err != nil {
+		return "", fmt.Errorf("failed to set owner reference: %w", err)
+	}
+
+	labels := job.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[LabelKeyJob] = "true"
+	labels[LabelKeyJobGroup] = jobName
+	job.SetLabels(labels)
+
+	annots := job.GetAnnotations()
+	if annots == nil {
+		annots = map[string]string{}
+	}
+	annots[AnnotKeyDiscordInteraction] = di.Name
+	if di.Spec.ApplicationRef != nil {
+		annots[AnnotKeyDiscordApplication] = di.Spec.ApplicationRef.Name
+	}
+	annots[AnnotKeyAction] = action.Name
+	annots[AnnotKeyDiscordInteractionToken] = inv.Token
+	annots[AnnotKeyInteractionID] = inv.InteractionID
+	annots[AnnotKeyUserID] = inv.UserID
+	annots[AnnotKeyUserName] = inv.UserName
+	if inv.ChannelID != "" {
+		annots[AnnotKeyReportChannelID] = inv.ChannelID
+	}
+	if inv.RunName != "" {
+		annots[AnnotKeyInteractionRun] = inv.RunName
+	} else {
+		annots[AnnotKeyInteractionRun] = job.GetName()
+	}
+	if queued {
+		annots[AnnotKeyQueued] = "true"
+	}
+	job.SetAnnotations(annots)
+
+	if err := k8sClient.Create(ctx, job); err != nil {
+		if k8serrors.IsAlreadyExists(err) {
+			// The Job name is derived from the interaction ID.
+			return "", ErrDuplicateInteraction
+		}
+		return "", fmt.Errorf(
+			"failed to create job: %s: %s: %w",
+			job.GetName(),
+			job.GetNamespace(),
+			err,
+		)
+	}
+	metrics.JobsCreatedTotal.WithLabelValues(di.Name, action.Name).Inc()
+	return job.GetName(), nil
+}
+
+// createInteractionRun creates the InteractionRun recording the run whose Job
+// is jobName. The InteractionRun has the same name as the Job.
+func createInteractionRun(
+	ctx context.Context,
+	k8sClient client.Client,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	inv *RunInvocation,
+	jobName string,
+	queued bool,
+) error {
+	run := &vahkanev1.InteractionRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        jobName,
+			Namespace:   di.Namespace,
+			Annotations: map[string]string{AnnotKeyDiscordInteractionToken: inv.Token},
+		},
+		Spec: vahkanev1.InteractionRunSpec{
+			DiscordInteractionRef: corev1.LocalObjectReference{Name: di.Name},
+			Action:                action.Name,
+			InteractionID:         inv.InteractionID,
+			ChannelID:             inv.ChannelID,
+			UserID:                inv.UserID,
+			UserName:              inv.UserName,
+			Options:               inv.Options,
+		},
+	}
+	if err := controllerutil.SetControllerReference(di, run, k8sClient.Scheme()); err != nil {
+		return fmt.Errorf("failed to set owner reference: %w", err)
+	}
+	if err := k8sClient.Create(ctx, run); err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	run.Status.JobName = jobName
+	run.Status.StartTime = &now
+	run.Status.Phase = vahkanev1.InteractionRunPhaseRunning
+	if queued {
+		run.Status.Phase = vahkanev1.InteractionRunPhaseQueued
+	}
+	return k8sClient.Status().Update(ctx, run)
+}
+
+// UpdateInteractionRunPhase sets the phase of the InteractionRun of the object
+// of a run. The completion time is set for the phases of finished runs. It
+// does nothing if the object has no InteractionRun. The InteractionRun is read
+// by apiReader, since it may have been created too recently to be in the
+// cache, and the update is retried on conflicts.
+func UpdateInteractionRunPhase(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	obj metav1.Object,
+	phase vahkanev1.InteractionRunPhase,
+) error {
+	name, ok := obj.GetAnnotations()[AnnotKeyInteractionRun]
+	if !ok {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var run vahkanev1.InteractionRun
+		if err := apiReader.Get(ctx, types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}, &run); err != nil {
+			// The InteractionRun failed to be created or has been pruned.
+			return client.IgnoreNotFound(err)
+		}
+		if run.Status.Phase == phase {
+			return nil
+		}
+		run.Status.Phase = phase
+		run.Status.JobName = obj.GetName()
+		if IsInteractionRunFinished(phase) {
+			now := metav1.Now()
+			run.Status.CompletionTime = &now
+		}
+		return k8sClient.Status().Update(ctx, &run)
+	})
+}
+
+// IsInteractionRunFinished reports whether the phase is one of finished runs.
+func IsInteractionRunFinished(phase vahkanev1.InteractionRunPhase) bool {
+	switch phase {
+	case vahkanev1.InteractionRunPhaseSucceeded,
+		vahkanev1.InteractionRunPhaseFailed,
+		vahkanev1.InteractionRunPhaseCancelled:
+		return true
+	}
+	return false
+}
+
+// InteractionRunPhaseOf returns the phase of the InteractionRun of a run that
+// finished with the result.
+func InteractionRunPhaseOf(result vahkanev1.RunResult) vahkanev1.InteractionRunPhase {
+	switch result {
+	case vahkanev1.RunResultSucceeded:
+		return vahkanev1.InteractionRunPhaseSucceeded
+	case vahkanev1.RunResultCancelled:
+		return vahkanev1.InteractionRunPhaseCancelled
+	default:
+		return vahkanev1.InteractionRunPhaseFailed
+	}
+}
@@ -0,0 +1,368 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestCreateRunObjectFromCronJob(t *testing.T) {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "maintenance", Namespace: "ns", UID: "cronjob-uid"},
+		Spec: batchv1.CronJobSpec{
+			Schedule: "0 0 * * *",
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "maintenance"}},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							RestartPolicy: corev1.RestartPolicyOnFailure,
+							Containers:    []corev1.Container{{Name: "main", Image: "busybox"}},
+						},
+					},
+				},
+			},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(cronJob).Build()
+
+	di := &vahkanev1.DiscordInteraction{ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"}}
+	action := &vahkanev1.DiscordInteractionAction{
+		Name: "action",
+		ActionInline: vahkanev1.DiscordInteractionActionInline{
+			CronJobRef: &corev1.LocalObjectReference{Name: "maintenance"},
+		},
+	}
+	inv := &RunInvocation{InteractionID: "1234", Token: "token", UserID: "user-id", UserName: "user"}
+
+	ctx := context.Background()
+	name, err := createRunObject(ctx, k8sClient, di, action, inv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "ns"}, &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Labels["app"] != "maintenance" || job.Labels[LabelKeyJob] != "true" {
+		t.Errorf("unexpected labels: %v", job.Labels)
+	}
+	if job.Annotations["cronjob.kubernetes.io/instantiate"] != "manual" ||
+		job.Annotations[AnnotKeyAction] != "action" {
+		t.Errorf("unexpected annotations: %v", job.Annotations)
+	}
+	if job.Spec.Template.Spec.Containers[0].Image != "busybox" ||
+		job.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("unexpected pod template: %v", job.Spec.Template.Spec)
+	}
+	if len(job.OwnerReferences) != 2 || job.OwnerReferences[0].UID != cronJob.UID ||
+		job.OwnerReferences[0].Controller != nil || job.OwnerReferences[1].UID != di.UID {
+		t.Errorf("unexpected owner references: %v", job.OwnerReferences)
+	}
+
+	action.ActionInline.CronJobRef.Name = "missing"
+	inv.InteractionID = "5678"
+	if _, err := createRunObject(ctx, k8sClient, di, action, inv, false); err == nil {
+		t.Error("createRunObject should fail for a missing CronJob")
+	}
+}
+
+func TestCheckConcurrencyLimit(t *testing.T) {
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "running",
+			Namespace:   "ns",
+			Labels:      map[string]string{LabelKeyJob: "true"},
+			Annotations: map[string]string{AnnotKeyDiscordInteraction: "di"},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(running).Build()
+	action := &vahkanev1.DiscordInteractionAction{Name: "action"}
+	ctx := context.Background()
+
+	limit := int32(1)
+	di := &vahkanev1.DiscordInteraction{ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"}}
+	if queued, err := checkConcurrencyLimit(ctx, k8sClient, di, action); queued || err != nil {
+		t.Errorf("a run without the limit should be allowed: %v: %v", queued, err)
+	}
+
+	di.Spec.MaxConcurrentJobs = &limit
+	if _, err := checkConcurrencyLimit(ctx, k8sClient, di, action); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("a run over the limit should be refused: %v", err)
+	}
+
+	di.Spec.ConcurrencyLimitPolicy = vahkanev1.ConcurrencyLimitPolicyQueue
+	if queued, err := checkConcurrencyLimit(ctx, k8sClient, di, action); !queued || err != nil {
+		t.Errorf("a run over the limit should be queued: %v: %v", queued, err)
+	}
+
+	limit = 2
+	if queued, err := checkConcurrencyLimit(ctx, k8sClient, di, action); queued || err != nil {
+		t.Errorf("a run under the limit should be allowed: %v: %v", queued, err)
+	}
+}
+
+func TestStartRunRecordsInteractionRun(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	ctx := context.Background()
+
+	jobName, queued, err := StartRun(ctx, k8sClient, k8sClient, di, &di.Spec.Actions[0], &RunInvocation{
+		InteractionID: "1234",
+		Token:         "token",
+		UserID:        "user-id",
+		Options:       map[string]string{"env": "prod"},
+	})
+	if err != nil || queued {
+		t.Fatalf("unexpected result: %v: %v", queued, err)
+	}
+
+	var run vahkanev1.InteractionRun
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: jobName, Namespace: "ns"}, &run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Spec.Action != "action" || run.Spec.InteractionID != "1234" || run.Spec.UserID != "user-id" ||
+		run.Spec.Options["env"] != "prod" || run.Spec.DiscordInteractionRef.Name != "di" {
+		t.Errorf("unexpected spec: %+v", run.Spec)
+	}
+	if run.Status.Phase != vahkanev1.InteractionRunPhaseRunning || run.Status.JobName != jobName {
+		t.Errorf("unexpected status: %+v", run.Status)
+	}
+	if owner := metav1.GetControllerOf(&run); owner == nil || owner.UID != di.UID {
+		t.Errorf("unexpected owner: %v", owner)
+	}
+
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: jobName, Namespace: "ns"}, &job); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := UpdateInteractionRunPhase(ctx, k8sClient, k8sClient, &job, InteractionRunPhaseOf(vahkanev1.RunResultFailed)); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: jobName, Namespace: "ns"}, &run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Status.Phase != vahkanev1.InteractionRunPhaseFailed || run.Status.CompletionTime == nil {
+		t.Errorf("unexpected status: %+v", run.Status)
+	}
+}
+
+func TestStartRunRetry(t *testing.T) {
+	limit := int32(1)
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			MaxConcurrentJobs: &limit,
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	action := &di.Spec.Actions[0]
+	ctx := context.Background()
+
+	// The previous attempt has taken the lock but failed to create the Job.
+	jobGroup := JobGroupName(di.Name, action)
+	if err := AcquireJobLock(ctx, k8sClient, k8sClient, di, action, jobGroup, RunJobName(jobGroup, "1234")); err != nil {
+		t.Fatal(err)
+	}
+	inv := &RunInvocation{InteractionID: "1234", Token: "token"}
+	jobName, queued, err := StartRun(ctx, k8sClient, k8sClient, di, action, inv)
+	if err != nil || queued {
+		t.Fatalf("the run should be started by the retry: %v: %v", queued, err)
+	}
+
+	// The run counts toward the limit and holds the lock, but a retry is
+	// not refused by them.
+	name, queued, err := StartRun(ctx, k8sClient, k8sClient, di, action, inv)
+	if !errors.Is(err, ErrDuplicateInteraction) || name != jobName || queued {
+		t.Errorf("the retry should find the started run: %s: %v: %v", name, queued, err)
+	}
+
+	// Other runs are still refused.
+	if _, _, err := StartRun(ctx, k8sClient, k8sClient, di, action, &RunInvocation{InteractionID: "5678"}); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("another run should be refused: %v", err)
+	}
+}
+
+func TestStartRunConcurrentAction(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name:       "action",
+				Concurrent: true,
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	action := &di.Spec.Actions[0]
+	ctx := context.Background()
+
+	inv := &RunInvocation{InteractionID: "1234", Token: "token"}
+	jobName, _, err := StartRun(ctx, k8sClient, k8sClient, di, action, inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := StartRun(ctx, k8sClient, k8sClient, di, action, &RunInvocation{InteractionID: "5678"}); err != nil {
+		t.Errorf("another run of a concurrent action should be started: %v", err)
+	}
+	if name, _, err := StartRun(ctx, k8sClient, k8sClient, di, action, inv); !errors.Is(err, ErrDuplicateInteraction) || name != jobName {
+		t.Errorf("the same interaction should not be run twice: %s: %v", name, err)
+	}
+
+	var leases coordinationv1.LeaseList
+	if err := k8sClient.List(ctx, &leases); err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Items) != 0 {
+		t.Errorf("a concurrent action should not take the lock: %v", leases.Items)
+	}
+}
+
+func TestUpdateInteractionRunPhaseRetriesOnConflict(t *testing.T) {
+	run := &vahkanev1.InteractionRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "ns"},
+		Status:     vahkanev1.InteractionRunStatus{Phase: vahkanev1.InteractionRunPhaseQueued},
+	}
+	conflicts := 0
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(run).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceUpdate: func(
+				ctx context.Context,
+				c client.Client,
+				subResource string,
+				obj client.Object,
+				opts ...client.SubResourceUpdateOption,
+			) error {
+				if conflicts == 0 {
+					conflicts++
+					return k8serrors.NewConflict(vahkanev1.GroupVersion.WithResource("interactionruns").GroupResource(),
+						obj.GetName(), errors.New("modified"))
+				}
+				return c.Status().Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	ctx := context.Background()
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:        "job",
+		Namespace:   "ns",
+		Annotations: map[string]string{AnnotKeyInteractionRun: "run"},
+	}}
+	if err := UpdateInteractionRunPhase(ctx, k8sClient, k8sClient, job, vahkanev1.InteractionRunPhaseRunning); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(run), run); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts != 1 || run.Status.Phase != vahkanev1.InteractionRunPhaseRunning || run.Status.JobName != "job" {
+		t.Errorf("the update should be retried: %d: %+v", conflicts, run.Status)
+	}
+
+	// A missing InteractionRun is not an error.
+	job.Annotations[AnnotKeyInteractionRun] = "missing"
+	if err := UpdateInteractionRunPhase(ctx, k8sClient, k8sClient, job, vahkanev1.InteractionRunPhaseRunning); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStartRunConcurrently(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", UID: "di-uid"},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "action",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	ctx := context.Background()
+
+	// Two interactions of the action are handled at once, e.g., by the
+	// webhook servers on different replicas.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = StartRun(ctx, k8sClient, k8sClient, di, &di.Spec.Actions[0], &RunInvocation{
+				InteractionID: fmt.Sprintf("interaction-%d", i),
+			})
+		}()
+	}
+	wg.Wait()
+
+	started, refused := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			started++
+		case errors.Is(err, ErrAlreadyRunning):
+			refused++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if started != 1 || refused != 1 {
+		t.Errorf("exactly one run should be started: %v", errs)
+	}
+	var jobList batchv1.JobList
+	if err := k8sClient.List(ctx, &jobList); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobList.Items) != 1 {
+		t.Errorf("unexpected number of Jobs: %d", len(jobList.Items))
+	}
+}
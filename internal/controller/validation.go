@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"fmt"
+	"text/template"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+)
+
+const (
+	conditionReasonValid          = "Valid"
+	conditionReasonInvalidActions = "InvalidActions"
+)
+
+// ParseReplyTemplate parses the content of the reply action as a template.
+// Missing keys of the options are rendered as their zero values.
+func ParseReplyTemplate(content string) (*template.Template, error) {
+	return template.New("reply").Option("missingkey=zero").Parse(content)
+}
+
+// validateActions returns the error describing the first action that can't be
+// run as specified. The CRD can't validate the contents of the strings, so
+// they are checked here.
+func validateActions(di *vahkanev1.DiscordInteraction) error {
+	for i := range di.Spec.Actions {
+		action := &di.Spec.Actions[i]
+		if reply := action.ActionInline.Reply; reply != nil && reply.Content != "" {
+			if _, err := ParseReplyTemplate(reply.Content); err != nil {
+				return fmt.Errorf("action %s: invalid template: %w", action.Name, err)
+			}
+		}
+		for _, param := range action.Parameters {
+			if param.Pattern == "" {
+				continue
+			}
+			if _, err := compileParameterPattern(param.Pattern); err != nil {
+				return fmt.Errorf("action %s: parameter %s: invalid pattern: %w", action.Name, param.Name, err)
+			}
+		}
+	}
+	return nil
+}
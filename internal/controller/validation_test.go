@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestUpdateActionsCondition(t *testing.T) {
+	ctx := context.Background()
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns", Generation: 2},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name: "runbook",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					Reply: &vahkanev1.DiscordInteractionActionReply{Content: "{{ .Options.name "},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.DiscordInteraction{}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewDiscordInteractionReconciler(k8sClient, k8sClient, k8sClient.Scheme(), nil, recorder, nil)
+
+	if err := reconciler.updateActionsCondition(ctx, di); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(di), di); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(di.Status.Conditions, vahkanev1.DiscordInteractionConditionActionsValid)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.ObservedGeneration != 2 ||
+		!strings.Contains(condition.Message, "action runbook") {
+		t.Fatalf("unexpected condition: %+v", condition)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonInvalidActions)
+
+	di.Spec.Actions[0].ActionInline.Reply.Content = "{{ .Options.name }}"
+	if err := reconciler.updateActionsCondition(ctx, di); err != nil {
+		t.Fatal(err)
+	}
+	condition = meta.FindStatusCondition(di.Status.Conditions, vahkanev1.DiscordInteractionConditionActionsValid)
+	if condition == nil || condition.Status != metav1.ConditionTrue {
+		t.Errorf("unexpected condition: %+v", condition)
+	}
+	k8stest.ExpectEventReasons(t, recorder)
+}
+
+func TestValidateActions(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr string
+	}{
+		{name: "no pattern"},
+		{name: "valid pattern", pattern: "[a-z]+"},
+		{name: "invalid pattern", pattern: "[a-z", wantErr: "action deploy: parameter env: invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			di := &vahkanev1.DiscordInteraction{
+				Spec: vahkanev1.DiscordInteractionSpec{
+					Actions: []vahkanev1.DiscordInteractionAction{{
+						Name:       "deploy",
+						Parameters: []vahkanev1.DiscordInteractionActionParameter{{Name: "env", Pattern: tt.pattern}},
+					}},
+				},
+			}
+			err := validateActions(di)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -6,22 +6,85 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
 )
 
+// MessageFlagEphemeral is the flag of messages visible only to the invoker.
+const MessageFlagEphemeral = 64
+
+// InteractionTokenLifetime is how long the token of an interaction can be used
+// to follow it up.
+const InteractionTokenLifetime = 15 * time.Minute
+
+// AllowedMentions controls which mentions in a message notify the mentioned.
+// cf. https://discord.com/developers/docs/resources/message#allowed-mentions-object
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// NoMentions returns the AllowedMentions that suppresses the notifications of
+// all mentions, such as those in the options given by the invoker.
+func NoMentions() *AllowedMentions {
+	return &AllowedMentions{Parse: []string{}}
+}
+
+// MaxContentLength is the maximum length of the content of a message.
+// cf. https://discord.com/developers/docs/resources/message#create-message-jsonform-params
+const MaxContentLength = 2000
+
+// TruncateContent cuts the content down to MaxContentLength bytes without
+// splitting a character, marking the cut with an ellipsis.
+func TruncateContent(content string) string {
+	const ellipsis = "…"
+	if len(content) <= MaxContentLength {
+		return content
+	}
+	end := MaxContentLength - len(ellipsis)
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return content[:end] + ellipsis
+}
+
 //go:generate ../../bin/mockgen -source=$GOFILE -package=$GOPACKAGE -destination=mock_$GOFILE
 
 type Client interface {
 	SendFollowupMessage(ctx context.Context, interactionToken, message string) error
+	SendFollowupMessageWithComponents(
+		ctx context.Context,
+		interactionToken, message string,
+		components []map[string]interface{},
+	) error
+	SendFollowupMessageWithAttachment(
+		ctx context.Context,
+		interactionToken, message, fileName string,
+		file []byte,
+		flags int,
+	) error
+	SendFollowupMessageJSON(ctx context.Context, interactionToken string, message []byte) error
+	DeleteOriginalResponse(ctx context.Context, interactionToken string) error
+	SendChannelMessage(ctx context.Context, channelID, message string) error
 	GetGuildCommands(ctx context.Context, guildID string) ([]map[string]interface{}, error)
 	RegisterGuildCommand(ctx context.Context, guildID, commandsJSON string) error
 	DeleteGuildCommand(ctx context.Context, guildID, commandID string) error
+	CreateInteractionResponse(ctx context.Context, interactionID, interactionToken string, response []byte) error
 }
 
 type RealClient struct {
-	applicationID, token string
-	httpClient           *http.Client
+	applicationID string
+	httpClient    *http.Client
+
+	mu    sync.RWMutex
+	token string
 }
 
 func NewRealClient(applicationID, token string) Client {
@@ -32,13 +95,32 @@ func NewRealClient(applicationID, token string) Client {
 	}
 }
 
-func (c *RealClient) sendRequest(req *http.Request) ([]byte, error) {
+// SetToken replaces the bot token used by the subsequent requests.
+func (c *RealClient) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+func (c *RealClient) getToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// sendRequest sends the request to Discord. endpoint is the name of the API
+// endpoint used as a metrics label.
+func (c *RealClient) sendRequest(req *http.Request, endpoint string) ([]byte, error) {
 	req.Header.Add("user-agent", "vahkane")
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("authorization", "Bot "+c.token)
+	if req.Header.Get("content-type") == "" {
+		req.Header.Add("content-type", "application/json")
+	}
+	req.Header.Add("authorization", "Bot "+c.getToken())
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		metrics.DiscordAPIErrorsTotal.WithLabelValues(endpoint, "").Inc()
 		return nil, err
 	}
 	defer func() {
@@ -47,7 +129,13 @@ func (c *RealClient) sendRequest(req *http.Request) ([]byte, error) {
 
 	body, _ := io.ReadAll(resp.Body)
 
+	statusCode := strconv.Itoa(resp.StatusCode)
+	metrics.DiscordAPIRequestDurationSeconds.
+		WithLabelValues(endpoint, statusCode).
+		Observe(time.Since(start).Seconds())
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.DiscordAPIErrorsTotal.WithLabelValues(endpoint, statusCode).Inc()
 		return nil, fmt.Errorf("fail to send request: %s: %s", resp.Status, body)
 	}
 
@@ -76,7 +164,170 @@ func (c *RealClient) SendFollowupMessage(
 		return err
 	}
 
-	_, err = c.sendRequest(req)
+	_, err = c.sendRequest(req, "followup")
+	return err
+}
+
+// SendChannelMessage posts the message to the channel as the bot, for messages
+// that don't answer any interaction.
+func (c *RealClient) SendChannelMessage(
+	ctx context.Context,
+	channelID, message string,
+) error {
+	// cf. https://discord.com/developers/docs/resources/message#create-message
+
+	endpoint := fmt.Sprintf("https://discord.com/api/v10/channels/%s/messages", channelID)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"content": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendRequest(req, "channel_message")
+	return err
+}
+
+func (c *RealClient) SendFollowupMessageWithComponents(
+	ctx context.Context,
+	interactionToken, message string,
+	components []map[string]interface{},
+) error {
+	// cf. https://discord.com/developers/docs/interactions/message-components
+
+	endpoint := fmt.Sprintf(
+		"https://discord.com/api/v10/webhooks/%s/%s",
+		c.applicationID,
+		interactionToken,
+	)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"content":    message,
+		"components": components,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendRequest(req, "followup")
+	return err
+}
+
+func (c *RealClient) SendFollowupMessageWithAttachment(
+	ctx context.Context,
+	interactionToken, message, fileName string,
+	file []byte,
+	flags int,
+) error {
+	// cf. https://discord.com/developers/docs/reference#uploading-files
+
+	endpoint := fmt.Sprintf(
+		"https://discord.com/api/v10/webhooks/%s/%s",
+		c.applicationID,
+		interactionToken,
+	)
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"content": message,
+		"attachments": []map[string]interface{}{
+			{"id": 0, "filename": fileName},
+		},
+		"flags": flags,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="payload_json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(payload); err != nil {
+		return err
+	}
+
+	part, err = writer.CreateFormFile("files[0]", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(file); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("content-type", writer.FormDataContentType())
+
+	_, err = c.sendRequest(req, "followup")
+	return err
+}
+
+// SendFollowupMessageJSON sends the follow-up message given as JSON, such as
+// one with embeds or flags.
+func (c *RealClient) SendFollowupMessageJSON(
+	ctx context.Context,
+	interactionToken string,
+	message []byte,
+) error {
+	endpoint := fmt.Sprintf(
+		"https://discord.com/api/v10/webhooks/%s/%s",
+		c.applicationID,
+		interactionToken,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendRequest(req, "followup")
+	return err
+}
+
+// DeleteOriginalResponse deletes the initial response to the interaction.
+// The next follow-up message is then sent as a new message, so its flags such
+// as EPHEMERAL take effect.
+func (c *RealClient) DeleteOriginalResponse(
+	ctx context.Context,
+	interactionToken string,
+) error {
+	// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#delete-original-interaction-response
+
+	endpoint := fmt.Sprintf(
+		"https://discord.com/api/v10/webhooks/%s/%s/messages/@original",
+		c.applicationID,
+		interactionToken,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendRequest(req, "delete_original_response")
 	return err
 }
 
@@ -97,7 +348,7 @@ func (c *RealClient) GetGuildCommands(
 		return nil, err
 	}
 
-	body, err := c.sendRequest(req)
+	body, err := c.sendRequest(req, "get_guild_commands")
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +379,7 @@ func (c *RealClient) RegisterGuildCommand(
 		return err
 	}
 
-	_, err = c.sendRequest(req)
+	_, err = c.sendRequest(req, "register_guild_command")
 	return err
 }
 
@@ -148,6 +399,30 @@ func (c *RealClient) DeleteGuildCommand(
 		return err
 	}
 
-	_, err = c.sendRequest(req)
+	_, err = c.sendRequest(req, "delete_guild_command")
+	return err
+}
+
+// CreateInteractionResponse responds to the interaction received through the
+// Gateway. response is the JSON of the interaction response.
+func (c *RealClient) CreateInteractionResponse(
+	ctx context.Context,
+	interactionID, interactionToken string,
+	response []byte,
+) error {
+	// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#create-interaction-response
+
+	endpoint := fmt.Sprintf(
+		"https://discord.com/api/v10/interactions/%s/%s/callback",
+		interactionID,
+		interactionToken,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(response))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.sendRequest(req, "interaction_callback")
 	return err
 }
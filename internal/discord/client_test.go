@@ -0,0 +1,30 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateContent(t *testing.T) {
+	if got := TruncateContent("short"); got != "short" {
+		t.Errorf("unexpected content: %q", got)
+	}
+	exact := strings.Repeat("x", MaxContentLength)
+	if got := TruncateContent(exact); got != exact {
+		t.Errorf("content of the maximum length was truncated")
+	}
+
+	for _, content := range []string{
+		strings.Repeat("x", MaxContentLength+1),
+		strings.Repeat("あ", MaxContentLength),
+	} {
+		got := TruncateContent(content)
+		if len(got) > MaxContentLength {
+			t.Errorf("too long content: %d", len(got))
+		}
+		if !utf8.ValidString(got) || !strings.HasSuffix(got, "…") {
+			t.Errorf("unexpected truncation: %q", got[len(got)-10:])
+		}
+	}
+}
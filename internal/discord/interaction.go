@@ -0,0 +1,299 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxInteractionSize is the maximum size of the body of an interaction.
+// Interactions are far smaller even with many resolved objects.
+const MaxInteractionSize = 1 << 20
+
+// ErrInvalidInteraction is returned by ParseInteraction if the body is not a
+// well-formed interaction.
+var ErrInvalidInteraction = errors.New("invalid interaction")
+
+// InteractionType is the type of an interaction.
+// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-interaction-type
+type InteractionType int
+
+const (
+	InteractionTypePing                           InteractionType = 1
+	InteractionTypeApplicationCommand             InteractionType = 2
+	InteractionTypeMessageComponent               InteractionType = 3
+	InteractionTypeApplicationCommandAutocomplete InteractionType = 4
+	InteractionTypeModalSubmit                    InteractionType = 5
+)
+
+// Interaction is the interaction sent by Discord to the webhook or through
+// the Gateway. Only the fields used by vahkane are defined, and unknown
+// fields are ignored because Discord adds fields without notice.
+// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object
+type Interaction struct {
+	ID            string           `json:"id"`
+	ApplicationID string           `json:"application_id,omitempty"`
+	Type          InteractionType  `json:"type"`
+	Data          *InteractionData `json:"data,omitempty"`
+	GuildID       string           `json:"guild_id,omitempty"`
+	ChannelID     string           `json:"channel_id,omitempty"`
+	// Member is set for interactions in guilds, and User is set for those
+	// in DMs.
+	Member      *Member  `json:"member,omitempty"`
+	User        *User    `json:"user,omitempty"`
+	Token       string   `json:"token"`
+	Version     int      `json:"version,omitempty"`
+	Message     *Message `json:"message,omitempty"`
+	Locale      string   `json:"locale,omitempty"`
+	GuildLocale string   `json:"guild_locale,omitempty"`
+
+	// RawData is the data as sent by Discord, including the fields that
+	// InteractionData lacks. It is set by ParseInteraction.
+	RawData json.RawMessage `json:"-"`
+}
+
+// Invoker returns the user who sent the interaction. It never returns nil.
+func (i *Interaction) Invoker() *User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return i.User
+	}
+	return &User{}
+}
+
+// InteractionData is the data of an application command or a message
+// component interaction. The fields of the other kind are empty.
+// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-interaction-data
+type InteractionData struct {
+	// The fields of application commands.
+	ID       string                  `json:"id,omitempty"`
+	Name     string                  `json:"name,omitempty"`
+	Type     int                     `json:"type,omitempty"`
+	Resolved *ResolvedData           `json:"resolved,omitempty"`
+	Options  []InteractionDataOption `json:"options,omitempty"`
+	GuildID  string                  `json:"guild_id,omitempty"`
+	TargetID string                  `json:"target_id,omitempty"`
+
+	// The fields of message components.
+	CustomID      string   `json:"custom_id,omitempty"`
+	ComponentType int      `json:"component_type,omitempty"`
+	Values        []string `json:"values,omitempty"`
+}
+
+// GetName returns the name of the invoked command. It returns "" if data is
+// nil.
+func (d *InteractionData) GetName() string {
+	if d == nil {
+		return ""
+	}
+	return d.Name
+}
+
+// OptionValues returns the values of the options by their names. The options
+// of subcommands are included. It returns an empty map if data is nil.
+func (d *InteractionData) OptionValues() map[string]interface{} {
+	values := map[string]interface{}{}
+	if d == nil {
+		return values
+	}
+	var walk func(options []InteractionDataOption)
+	walk = func(options []InteractionDataOption) {
+		for i := range options {
+			if options[i].Value != nil {
+				values[options[i].Name] = options[i].Value
+			}
+			walk(options[i].Options)
+		}
+	}
+	walk(d.Options)
+	return values
+}
+
+// InteractionDataOption is an option of an application command, or a
+// subcommand with its own options.
+// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-application-command-interaction-data-option-structure
+type InteractionDataOption struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	// Value is a string, a float64 or a bool as decoded from JSON. The IDs
+	// of users, channels, roles and attachments are strings, and the
+	// objects are in ResolvedData.
+	Value   interface{}             `json:"value,omitempty"`
+	Options []InteractionDataOption `json:"options,omitempty"`
+	Focused bool                    `json:"focused,omitempty"`
+}
+
+// ResolvedData holds the objects referred to by the options by their IDs.
+// cf. https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-resolved-data-structure
+type ResolvedData struct {
+	Users map[string]User `json:"users,omitempty"`
+	// Members lack User, which is in Users.
+	Members     map[string]Member     `json:"members,omitempty"`
+	Roles       map[string]Role       `json:"roles,omitempty"`
+	Channels    map[string]Channel    `json:"channels,omitempty"`
+	Messages    map[string]Message    `json:"messages,omitempty"`
+	Attachments map[string]Attachment `json:"attachments,omitempty"`
+}
+
+// User is a Discord user.
+// cf. https://discord.com/developers/docs/resources/user#user-object
+type User struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	GlobalName    string `json:"global_name,omitempty"`
+	Discriminator string `json:"discriminator,omitempty"`
+	Avatar        string `json:"avatar,omitempty"`
+	Bot           bool   `json:"bot,omitempty"`
+}
+
+// Member is a user in a guild.
+// cf. https://discord.com/developers/docs/resources/guild#guild-member-object
+type Member struct {
+	User        *User    `json:"user,omitempty"`
+	Nick        string   `json:"nick,omitempty"`
+	Roles       []string `json:"roles"`
+	JoinedAt    string   `json:"joined_at,omitempty"`
+	Permissions string   `json:"permissions,omitempty"`
+}
+
+// Role is a role in a guild.
+// cf. https://discord.com/developers/docs/topics/permissions#role-object
+type Role struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Color       int    `json:"color,omitempty"`
+	Position    int    `json:"position,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+	Managed     bool   `json:"managed,omitempty"`
+	Mentionable bool   `json:"mentionable,omitempty"`
+}
+
+// Channel is a partial channel resolved from an option.
+// cf. https://discord.com/developers/docs/resources/channel#channel-object
+type Channel struct {
+	ID          string `json:"id"`
+	Type        int    `json:"type"`
+	Name        string `json:"name,omitempty"`
+	ParentID    string `json:"parent_id,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// Attachment is a file attached to an option or a message.
+// cf. https://discord.com/developers/docs/resources/channel#attachment-object
+type Attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	URL         string `json:"url"`
+}
+
+// Message is a message, such as the one with the component that sent the
+// interaction.
+// cf. https://discord.com/developers/docs/resources/channel#message-object
+type Message struct {
+	ID          string       `json:"id"`
+	ChannelID   string       `json:"channel_id"`
+	Author      *User        `json:"author,omitempty"`
+	Content     string       `json:"content"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+	Flags       int          `json:"flags,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Components  []Component  `json:"components,omitempty"`
+}
+
+// Component is a message component: an action row or one of the components
+// in it.
+// cf. https://discord.com/developers/docs/interactions/message-components#component-object
+type Component struct {
+	Type       int         `json:"type"`
+	CustomID   string      `json:"custom_id,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	Style      int         `json:"style,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Disabled   bool        `json:"disabled,omitempty"`
+	Components []Component `json:"components,omitempty"`
+}
+
+// ParseInteraction decodes the body of an interaction. The errors wrap
+// ErrInvalidInteraction if the body is too large, isn't a single JSON object,
+// has a field of a wrong type, or lacks the fields required for its type.
+func ParseInteraction(body []byte) (*Interaction, error) {
+	if len(body) > MaxInteractionSize {
+		return nil, fmt.Errorf("%w: the body exceeds %d bytes", ErrInvalidInteraction, MaxInteractionSize)
+	}
+
+	var interaction Interaction
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&interaction); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInteraction, describeDecodeError(err))
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: unexpected data after the object", ErrInvalidInteraction)
+	}
+	var raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInteraction, describeDecodeError(err))
+	}
+	if interaction.Data != nil {
+		interaction.RawData = raw.Data
+	}
+
+	switch interaction.Type {
+	case InteractionTypePing:
+		return &interaction, nil
+	case 0:
+		return nil, fmt.Errorf("%w: type is missing", ErrInvalidInteraction)
+	}
+	if interaction.ID == "" || interaction.Token == "" {
+		return nil, fmt.Errorf("%w: id and token are required", ErrInvalidInteraction)
+	}
+	switch interaction.Type {
+	case InteractionTypeApplicationCommand, InteractionTypeMessageComponent:
+		if interaction.Data == nil {
+			return nil, fmt.Errorf("%w: data is required", ErrInvalidInteraction)
+		}
+	}
+	if interaction.Data != nil {
+		if err := validateOptions(interaction.Data.Options); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInteraction, err)
+		}
+	}
+	return &interaction, nil
+}
+
+// validateOptions checks that the values of the options are scalars.
+func validateOptions(options []InteractionDataOption) error {
+	for i := range options {
+		switch options[i].Value.(type) {
+		case nil, string, float64, bool:
+		default:
+			return fmt.Errorf("the value of option %s must be a string, a number or a boolean", options[i].Name)
+		}
+		if err := validateOptions(options[i].Options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeDecodeError returns err with the location of the problem.
+func describeDecodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at offset %d: %w", syntaxErr.Offset, err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%s must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("truncated JSON: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInteraction(t *testing.T) {
+	interaction, err := ParseInteraction([]byte(`{
+		"id": "1234",
+		"application_id": "app",
+		"type": 2,
+		"token": "token",
+		"version": 1,
+		"guild_id": "guild",
+		"channel_id": "channel",
+		"locale": "ja",
+		"member": {"user": {"id": "user-id", "username": "user"}, "roles": ["role-id"]},
+		"data": {
+			"id": "command-id",
+			"name": "deploy",
+			"type": 1,
+			"options": [{"name": "to", "type": 6, "value": "target-id"}],
+			"resolved": {"users": {"target-id": {"id": "target-id", "username": "target"}}}
+		},
+		"app_permissions": "0"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interaction.Type != InteractionTypeApplicationCommand || interaction.Locale != "ja" ||
+		interaction.Invoker().ID != "user-id" || !reflect.DeepEqual(interaction.Member.Roles, []string{"role-id"}) {
+		t.Errorf("unexpected interaction: %+v", interaction)
+	}
+	if user := interaction.Data.Resolved.Users["target-id"]; user.Username != "target" {
+		t.Errorf("unexpected resolved user: %+v", user)
+	}
+	if !strings.HasPrefix(string(interaction.RawData), "{") || !strings.Contains(string(interaction.RawData), `"resolved"`) {
+		t.Errorf("unexpected raw data: %s", interaction.RawData)
+	}
+
+	for _, tt := range []struct {
+		name, body, problem string
+	}{
+		{name: "malformed", body: `{"type": 2,`, problem: "truncated"},
+		{name: "trailing data", body: `{"type": 1} {}`, problem: "after the object"},
+		{name: "wrong type", body: `{"type": "2"}`, problem: "type must be"},
+		{name: "no type", body: `{}`, problem: "type is missing"},
+		{name: "no token", body: `{"type": 2, "id": "1234", "data": {}}`, problem: "token"},
+		{name: "no data", body: `{"type": 3, "id": "1234", "token": "token"}`, problem: "data"},
+		{
+			name:    "object option",
+			body:    `{"type": 2, "id": "1234", "token": "token", "data": {"options": [{"name": "a", "value": {}}]}}`,
+			problem: "option a",
+		},
+		{name: "too large", body: `{"type": 1, "x": "` + strings.Repeat("x", MaxInteractionSize) + `"}`, problem: "exceeds"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseInteraction([]byte(tt.body))
+			if !errors.Is(err, ErrInvalidInteraction) || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	if interaction, err := ParseInteraction([]byte(`{"type": 1}`)); err != nil ||
+		interaction.Type != InteractionTypePing {
+		t.Errorf("unexpected result: %v: %v", interaction, err)
+	}
+}
+
+func TestOptionValues(t *testing.T) {
+	data := &InteractionData{
+		Name: "deploy",
+		Options: []InteractionDataOption{{
+			Name: "scale",
+			Type: 1, // SUB_COMMAND
+			Options: []InteractionDataOption{
+				{Name: "target", Type: 3, Value: "web"},
+				{Name: "replicas", Type: 4, Value: float64(3)},
+			},
+		}},
+	}
+	expected := map[string]interface{}{"target": "web", "replicas": float64(3)}
+	if got := data.OptionValues(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected options: %v", got)
+	}
+	if got := (*InteractionData)(nil).OptionValues(); len(got) != 0 {
+		t.Errorf("unexpected options: %v", got)
+	}
+}
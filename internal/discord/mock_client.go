@@ -40,6 +40,20 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// CreateInteractionResponse mocks base method.
+func (m *MockClient) CreateInteractionResponse(ctx context.Context, interactionID, interactionToken string, response []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInteractionResponse", ctx, interactionID, interactionToken, response)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInteractionResponse indicates an expected call of CreateInteractionResponse.
+func (mr *MockClientMockRecorder) CreateInteractionResponse(ctx, interactionID, interactionToken, response any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInteractionResponse", reflect.TypeOf((*MockClient)(nil).CreateInteractionResponse), ctx, interactionID, interactionToken, response)
+}
+
 // DeleteGuildCommand mocks base method.
 func (m *MockClient) DeleteGuildCommand(ctx context.Context, guildID, commandID string) error {
 	m.ctrl.T.Helper()
@@ -54,6 +68,20 @@ func (mr *MockClientMockRecorder) DeleteGuildCommand(ctx, guildID, commandID any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGuildCommand", reflect.TypeOf((*MockClient)(nil).DeleteGuildCommand), ctx, guildID, commandID)
 }
 
+// DeleteOriginalResponse mocks base method.
+func (m *MockClient) DeleteOriginalResponse(ctx context.Context, interactionToken string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOriginalResponse", ctx, interactionToken)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteOriginalResponse indicates an expected call of DeleteOriginalResponse.
+func (mr *MockClientMockRecorder) DeleteOriginalResponse(ctx, interactionToken any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOriginalResponse", reflect.TypeOf((*MockClient)(nil).DeleteOriginalResponse), ctx, interactionToken)
+}
+
 // GetGuildCommands mocks base method.
 func (m *MockClient) GetGuildCommands(ctx context.Context, guildID string) ([]map[string]any, error) {
 	m.ctrl.T.Helper()
@@ -83,6 +111,20 @@ func (mr *MockClientMockRecorder) RegisterGuildCommand(ctx, guildID, commandsJSO
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterGuildCommand", reflect.TypeOf((*MockClient)(nil).RegisterGuildCommand), ctx, guildID, commandsJSON)
 }
 
+// SendChannelMessage mocks base method.
+func (m *MockClient) SendChannelMessage(ctx context.Context, channelID, message string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendChannelMessage", ctx, channelID, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendChannelMessage indicates an expected call of SendChannelMessage.
+func (mr *MockClientMockRecorder) SendChannelMessage(ctx, channelID, message any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendChannelMessage", reflect.TypeOf((*MockClient)(nil).SendChannelMessage), ctx, channelID, message)
+}
+
 // SendFollowupMessage mocks base method.
 func (m *MockClient) SendFollowupMessage(ctx context.Context, interactionToken, message string) error {
 	m.ctrl.T.Helper()
@@ -96,3 +138,45 @@ func (mr *MockClientMockRecorder) SendFollowupMessage(ctx, interactionToken, mes
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendFollowupMessage", reflect.TypeOf((*MockClient)(nil).SendFollowupMessage), ctx, interactionToken, message)
 }
+
+// SendFollowupMessageJSON mocks base method.
+func (m *MockClient) SendFollowupMessageJSON(ctx context.Context, interactionToken string, message []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendFollowupMessageJSON", ctx, interactionToken, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendFollowupMessageJSON indicates an expected call of SendFollowupMessageJSON.
+func (mr *MockClientMockRecorder) SendFollowupMessageJSON(ctx, interactionToken, message any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendFollowupMessageJSON", reflect.TypeOf((*MockClient)(nil).SendFollowupMessageJSON), ctx, interactionToken, message)
+}
+
+// SendFollowupMessageWithAttachment mocks base method.
+func (m *MockClient) SendFollowupMessageWithAttachment(ctx context.Context, interactionToken, message, fileName string, file []byte, flags int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendFollowupMessageWithAttachment", ctx, interactionToken, message, fileName, file, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendFollowupMessageWithAttachment indicates an expected call of SendFollowupMessageWithAttachment.
+func (mr *MockClientMockRecorder) SendFollowupMessageWithAttachment(ctx, interactionToken, message, fileName, file, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendFollowupMessageWithAttachment", reflect.TypeOf((*MockClient)(nil).SendFollowupMessageWithAttachment), ctx, interactionToken, message, fileName, file, flags)
+}
+
+// SendFollowupMessageWithComponents mocks base method.
+func (m *MockClient) SendFollowupMessageWithComponents(ctx context.Context, interactionToken, message string, components []map[string]any) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendFollowupMessageWithComponents", ctx, interactionToken, message, components)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendFollowupMessageWithComponents indicates an expected call of SendFollowupMessageWithComponents.
+func (mr *MockClientMockRecorder) SendFollowupMessageWithComponents(ctx, interactionToken, message, components any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendFollowupMessageWithComponents", reflect.TypeOf((*MockClient)(nil).SendFollowupMessageWithComponents), ctx, interactionToken, message, components)
+}
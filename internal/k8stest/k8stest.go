@@ -0,0 +1,73 @@
+// Package k8stest provides the helpers shared by the tests that run against a
+// fake Kubernetes client.
+package k8stest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// NewFakeClientBuilder returns the builder of a fake client that knows the
+// built-in kinds and the kinds of vahkane.
+func NewFakeClientBuilder(t *testing.T) *fake.ClientBuilder {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := vahkanev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme)
+}
+
+// NewStaleCacheClient returns a client that writes through k8sClient but
+// reads from cache, like the client of a manager whose cache has not caught
+// up with the API server yet. k8sClient itself stands for the API reader.
+func NewStaleCacheClient(k8sClient client.Client, cache client.Reader) client.Client {
+	return &staleCacheClient{Client: k8sClient, cache: cache}
+}
+
+type staleCacheClient struct {
+	client.Client
+	cache client.Reader
+}
+
+func (c *staleCacheClient) Get(
+	ctx context.Context,
+	key client.ObjectKey,
+	obj client.Object,
+	opts ...client.GetOption,
+) error {
+	return c.cache.Get(ctx, key, obj, opts...)
+}
+
+func (c *staleCacheClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.cache.List(ctx, list, opts...)
+}
+
+// ExpectEventReasons fails the test unless the events recorded since the last
+// call have the reasons in order.
+func ExpectEventReasons(t *testing.T, recorder *record.FakeRecorder, reasons ...string) {
+	t.Helper()
+	var got []string
+	for len(recorder.Events) > 0 {
+		// FakeRecorder formats an event as "<type> <reason> <message>".
+		fields := strings.Fields(<-recorder.Events)
+		if len(fields) > 1 {
+			got = append(got, fields[1])
+		}
+	}
+	if !slices.Equal(got, reasons) {
+		t.Errorf("unexpected event reasons: %v, want %v", got, reasons)
+	}
+}
@@ -0,0 +1,128 @@
+// Package metrics defines the Prometheus metrics exposed by vahkane. They are
+// registered with controller-runtime's registry, so they are served on the
+// manager's metrics endpoint.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "vahkane"
+
+var (
+	WebhookRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_requests_total",
+			Help:      "Total number of Discord webhook requests by interaction type and result.",
+		},
+		[]string{"type", "result"},
+	)
+
+	SignatureVerificationFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signature_verification_failures_total",
+			Help:      "Total number of Discord webhook requests whose signature could not be verified.",
+		},
+	)
+
+	ActionMatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "action_matches_total",
+			Help:      "Total number of commands that matched an action.",
+		},
+		[]string{"discord_interaction", "action"},
+	)
+
+	ActionMissesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "action_misses_total",
+			Help:      "Total number of commands that matched no action.",
+		},
+		[]string{"discord_interaction"},
+	)
+
+	ActionsRateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "actions_rate_limited_total",
+			Help:      "Total number of commands refused by the rate limits of actions.",
+		},
+		[]string{"discord_interaction", "action"},
+	)
+
+	InteractionsShedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "interactions_shed_total",
+			Help:      "Total number of interactions refused because the workers were busy.",
+		},
+	)
+
+	JobsCreatedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_created_total",
+			Help:      "Total number of Jobs created for actions.",
+		},
+		[]string{"discord_interaction", "action"},
+	)
+
+	JobsFinishedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_finished_total",
+			Help:      "Total number of Jobs finished by result.",
+		},
+		[]string{"discord_interaction", "action", "result"},
+	)
+
+	JobDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Duration of finished Jobs from start to completion.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+		},
+		[]string{"discord_interaction", "action", "result"},
+	)
+
+	DiscordAPIRequestDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "discord_api_request_duration_seconds",
+			Help:      "Latency of Discord API requests by endpoint and status code.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"endpoint", "status_code"},
+	)
+
+	DiscordAPIErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discord_api_errors_total",
+			Help:      "Total number of failed Discord API requests by endpoint and status code.",
+		},
+		[]string{"endpoint", "status_code"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		WebhookRequestsTotal,
+		SignatureVerificationFailuresTotal,
+		ActionMatchesTotal,
+		ActionMissesTotal,
+		ActionsRateLimitedTotal,
+		InteractionsShedTotal,
+		JobsCreatedTotal,
+		JobsFinishedTotal,
+		JobDurationSeconds,
+		DiscordAPIRequestDurationSeconds,
+		DiscordAPIErrorsTotal,
+	)
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"context"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+)
+
+// newAuditEntry returns the audit entry of the application command. di and
+// action may be nil if they are not known.
+func newAuditEntry(
+	req *requestApplicationCommand,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	decision audit.Decision,
+) *audit.Entry {
+	entry := &audit.Entry{
+		Time:          time.Now(),
+		InteractionID: req.ID,
+		GuildID:       req.GuildID,
+		ChannelID:     req.ChannelID,
+		UserID:        req.Invoker().ID,
+		UserName:      req.Invoker().Username,
+		Decision:      decision,
+	}
+	if len(req.RawData) > 0 {
+		entry.Command = req.RawData
+	}
+	if di != nil {
+		entry.DiscordInteraction = di.GetNamespace() + "/" + di.GetName()
+	}
+	if action != nil {
+		entry.Action = action.Name
+	}
+	return entry
+}
+
+// writeAudit writes the entry to the audit sink. Failures are only logged so
+// that they don't affect the interaction.
+func (r *interactionHandler) writeAudit(entry *audit.Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.audit.Write(ctx, entry); err != nil {
+		r.logger.Error(err, "failed to write audit entry", "interaction_id", entry.InteractionID)
+	}
+}
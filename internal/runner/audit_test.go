@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"encoding/json"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewAuditEntry(t *testing.T) {
+	req := &requestApplicationCommand{Interaction: discord.Interaction{
+		Member:    &discord.Member{User: &discord.User{ID: "user-id", Username: "user"}},
+		Data:      &discord.InteractionData{Name: "deploy"},
+		RawData:   json.RawMessage(`{"name":"deploy","new_field":"x"}`),
+		GuildID:   "guild-id",
+		ChannelID: "channel-id",
+		Token:     "token",
+		ID:        "interaction-id",
+	}}
+	di := &vahkanev1.DiscordInteraction{ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"}}
+	action := &vahkanev1.DiscordInteractionAction{Name: "deploy"}
+
+	entry := newAuditEntry(req, di, action, audit.DecisionQueued)
+	if entry.InteractionID != "interaction-id" || entry.GuildID != "guild-id" ||
+		entry.ChannelID != "channel-id" || entry.UserID != "user-id" || entry.UserName != "user" ||
+		entry.DiscordInteraction != "ns/di" || entry.Action != "deploy" ||
+		entry.Decision != audit.DecisionQueued || entry.Time.IsZero() {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if command, err := json.Marshal(entry.Command); err != nil || string(command) != `{"name":"deploy","new_field":"x"}` {
+		t.Errorf("unexpected command: %s: %v", command, err)
+	}
+
+	entry = newAuditEntry(req, nil, nil, audit.DecisionNoMatch)
+	if entry.DiscordInteraction != "" || entry.Action != "" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
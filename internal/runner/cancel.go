@@ -0,0 +1,183 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const customIDPrefixCancel = "vahkane-cancel:"
+
+var (
+	errJobAlreadyFinished = errors.New("job already finished")
+	errForbidden          = errors.New("forbidden")
+)
+
+// makeCancelButton returns the components of a message that has a button to
+// cancel the Job.
+func makeCancelButton(job types.NamespacedName) []map[string]interface{} {
+	// cf. https://discord.com/developers/docs/interactions/message-components#buttons
+	return []map[string]interface{}{
+		{
+			"type": 1, // ACTION_ROW
+			"components": []map[string]interface{}{
+				{
+					"type":      2, // BUTTON
+					"style":     4, // DANGER
+					"label":     "Cancel",
+					"custom_id": customIDPrefixCancel + job.String(),
+				},
+			},
+		},
+	}
+}
+
+// parseCancelCustomID returns the Job identified by the custom ID of a cancel
+// button.
+func parseCancelCustomID(customID string) (types.NamespacedName, bool) {
+	rest, ok := strings.CutPrefix(customID, customIDPrefixCancel)
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+	namespace, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+func (r *interactionHandler) handleMessageComponent(
+	w http.ResponseWriter,
+	req *discord.Interaction,
+	app *controller.Application,
+) error {
+	job, ok := parseCancelCustomID(req.Data.CustomID)
+	if !ok {
+		r.logger.Info("unexpected custom id", "custom_id", req.Data.CustomID)
+		return respondMessage(w, ":x: unknown component", true)
+	}
+
+	// Discord requires a response within 3 seconds, so cancel the Job synchronously.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	cancelled, err := cancelJob(ctx, r.k8sClient, r.apiReader, r.recorder, app.Key, job, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errJobAlreadyFinished):
+			return respondMessage(w, ":x: the job has already finished", true)
+		case errors.Is(err, errForbidden):
+			return respondMessage(w, ":x: you are not allowed to cancel the job", true)
+		}
+		r.logger.Error(err, "failed to cancel job", "job", job)
+		return respondMessage(w, ":x: failed to cancel the job", true)
+	}
+	r.logger.Info("job cancelled", "job", job, "user", req.Invoker().ID)
+	annots := cancelled.GetAnnotations()
+	r.writeAudit(&audit.Entry{
+		Time:               time.Now(),
+		InteractionID:      req.ID,
+		DiscordInteraction: cancelled.GetNamespace() + "/" + annots[controller.AnnotKeyDiscordInteraction],
+		GuildID:            req.GuildID,
+		ChannelID:          req.ChannelID,
+		UserID:             req.Invoker().ID,
+		UserName:           req.Invoker().Username,
+		Action:             annots[controller.AnnotKeyAction],
+		Decision:           audit.DecisionCancelled,
+		JobName:            job.Name,
+		Result:             string(vahkanev1.RunResultCancelled),
+	})
+
+	return respondUpdateMessage(w, fmt.Sprintf(":stop_sign: cancelled by <@%s>", req.Invoker().ID))
+}
+
+// cancelJob deletes the running Job, records the run as cancelled and returns
+// the Job. The Job must belong to the DiscordInteraction of the guild where the interaction
+// was sent. Actions have no access rules of their own, so anyone who can
+// invoke the action is allowed to cancel it.
+func cancelJob(
+	ctx context.Context,
+	k8sClient client.Client,
+	apiReader client.Reader,
+	recorder record.EventRecorder,
+	appKey types.NamespacedName,
+	jobName types.NamespacedName,
+	req *discord.Interaction,
+) (*batchv1.Job, error) {
+	// The Job is read bypassing the cache, which may not have it yet if it
+	// has just been created or resumed.
+	var job batchv1.Job
+	if err := apiReader.Get(ctx, jobName, &job); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, errJobAlreadyFinished
+		}
+		return nil, fmt.Errorf("failed to get Job: %w", err)
+	}
+	if _, ok := job.GetLabels()[controller.LabelKeyJob]; !ok {
+		return nil, errForbidden
+	}
+	if controller.IsJobFinished(&job) || !job.GetDeletionTimestamp().IsZero() {
+		return nil, errJobAlreadyFinished
+	}
+
+	di, err := fetchDiscordInteractionByGuildID(ctx, k8sClient, appKey, req.GuildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch DiscordInteraction by guild id: %w", err)
+	}
+	if job.GetNamespace() != di.GetNamespace() ||
+		job.GetAnnotations()[controller.AnnotKeyDiscordInteraction] != di.GetName() {
+		recorder.Eventf(di, corev1.EventTypeWarning, eventReasonForbidden,
+			"Refused to cancel Job %s of another DiscordInteraction for user %s", jobName, req.Invoker().ID)
+		return nil, errForbidden
+	}
+
+	// The run is recorded as cancelled only once the Job is deleted. The Job
+	// that is already gone has finished and is reported as such.
+	propagationPolicy := metav1.DeletePropagationBackground
+	if err := k8sClient.Delete(ctx, &job, &client.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, errJobAlreadyFinished
+		}
+		return nil, fmt.Errorf("failed to delete Job: %w", err)
+	}
+
+	// The Job has been cancelled, so the failures of the bookkeeping below
+	// are only logged and don't stop each other.
+	logger := log.FromContext(ctx).WithValues("job", jobName)
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := apiReader.Get(ctx, client.ObjectKeyFromObject(di), di); err != nil {
+			return err
+		}
+		return controller.RecordRun(ctx, k8sClient, di, &job, vahkanev1.RunResultCancelled)
+	}); err != nil {
+		logger.Error(err, "failed to record the run")
+	}
+	if err := controller.UpdateInteractionRunPhase(ctx, k8sClient, apiReader, &job, vahkanev1.InteractionRunPhaseCancelled); err != nil {
+		logger.Error(err, "failed to update InteractionRun")
+	}
+	if err := controller.ReleaseJobLock(ctx, k8sClient, apiReader, &job); err != nil {
+		logger.Error(err, "failed to release the job lock")
+	}
+	recorder.Eventf(di, corev1.EventTypeNormal, eventReasonJobCancelled,
+		"Job %s was cancelled by user %s", jobName, req.Invoker().ID)
+
+	return &job, nil
+}
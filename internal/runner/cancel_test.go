@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestCancelJob(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "di",
+			Namespace: "ns",
+			Labels:    map[string]string{controller.LabelKeyDiscordGuildID: "guild"},
+		},
+	}
+	newJob := func(namespace, name, diName string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{controller.LabelKeyJob: "true"},
+				Annotations: map[string]string{
+					controller.AnnotKeyDiscordInteraction: diName,
+					controller.AnnotKeyAction:             "action",
+				},
+			},
+		}
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, newJob("ns", "job-a", "di"), newJob("ns", "job-b", "other"), newJob("ns2", "job-a", "di")).
+		WithStatusSubresource(di).
+		Build()
+
+	recorder := record.NewFakeRecorder(10)
+	req := &discord.Interaction{GuildID: "guild", User: &discord.User{ID: "user-id", Username: "user"}}
+
+	ctx := context.Background()
+	if _, err := cancelJob(ctx, k8sClient, k8sClient, recorder, types.NamespacedName{}, types.NamespacedName{Namespace: "ns", Name: "job-b"}, req); !errors.Is(err, errForbidden) {
+		t.Errorf("cancelJob should refuse a Job of another DiscordInteraction: %v", err)
+	}
+	if _, err := cancelJob(ctx, k8sClient, k8sClient, recorder, types.NamespacedName{}, types.NamespacedName{Namespace: "ns2", Name: "job-a"}, req); !errors.Is(err, errForbidden) {
+		t.Errorf("cancelJob should refuse a Job in another namespace: %v", err)
+	}
+	if _, err := cancelJob(ctx, k8sClient, k8sClient, recorder, types.NamespacedName{}, types.NamespacedName{Namespace: "ns", Name: "job-c"}, req); !errors.Is(err, errJobAlreadyFinished) {
+		t.Errorf("cancelJob should report a missing Job as finished: %v", err)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonForbidden, eventReasonForbidden)
+	if _, err := cancelJob(ctx, k8sClient, k8sClient, recorder, types.NamespacedName{}, types.NamespacedName{Namespace: "ns", Name: "job-a"}, req); err != nil {
+		t.Fatalf("cancelJob failed: %v", err)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonJobCancelled)
+
+	var job batchv1.Job
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "job-a", Namespace: "ns"}, &job); !k8serrors.IsNotFound(err) {
+		t.Errorf("the Job should be deleted: %v", err)
+	}
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "di", Namespace: "ns"}, di); err != nil {
+		t.Fatal(err)
+	}
+	if len(di.Status.History) != 1 || di.Status.History[0].Result != vahkanev1.RunResultCancelled {
+		t.Errorf("the run should be recorded as cancelled: %v", di.Status.History)
+	}
+
+	// The Job deleted by someone else has finished, so it is not recorded as
+	// cancelled.
+	k8sClient = k8stest.NewFakeClientBuilder(t).
+		WithObjects(di, newJob("ns", "job-d", "di")).
+		WithStatusSubresource(di).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				return k8serrors.NewNotFound(batchv1.Resource("jobs"), obj.GetName())
+			},
+		}).
+		Build()
+	if _, err := cancelJob(ctx, k8sClient, k8sClient, recorder, types.NamespacedName{}, types.NamespacedName{Namespace: "ns", Name: "job-d"}, req); !errors.Is(err, errJobAlreadyFinished) {
+		t.Errorf("cancelJob should report a Job deleted meanwhile as finished: %v", err)
+	}
+	k8stest.ExpectEventReasons(t, recorder)
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "di", Namespace: "ns"}, di); err != nil {
+		t.Fatal(err)
+	}
+	if len(di.Status.History) != 1 {
+		t.Errorf("the Job deleted meanwhile should not be recorded: %v", di.Status.History)
+	}
+}
+
+func TestParseCancelCustomID(t *testing.T) {
+	job := types.NamespacedName{Namespace: "ns", Name: "job-a"}
+	components := makeCancelButton(job)
+	customID := components[0]["components"].([]map[string]interface{})[0]["custom_id"].(string)
+	parsed, ok := parseCancelCustomID(customID)
+	if !ok || parsed != job {
+		t.Errorf("parseCancelCustomID returns unexpected value: %s: %v", customID, parsed)
+	}
+
+	for _, customID := range []string{"job-a", customIDPrefixCancel + "job-a"} {
+		if _, ok := parseCancelCustomID(customID); ok {
+			t.Errorf("parseCancelCustomID should reject invalid custom id: %s", customID)
+		}
+	}
+}
+
+func TestCancelJobBookkeeping(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "di",
+			Namespace: "ns",
+			Labels:    map[string]string{controller.LabelKeyDiscordGuildID: "guild"},
+		},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "job",
+			Namespace: "ns",
+			Labels:    map[string]string{controller.LabelKeyJob: "true", controller.LabelKeyJobGroup: "group"},
+			Annotations: map[string]string{
+				controller.AnnotKeyDiscordInteraction: "di",
+				controller.AnnotKeyInteractionRun:     "run",
+			},
+		},
+	}
+	run := &vahkanev1.InteractionRun{ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "ns"}}
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "ns"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: ptr.To("job")},
+	}
+	req := &discord.Interaction{GuildID: "guild", User: &discord.User{ID: "user-id", Username: "user"}}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name          string
+		historyErrors int
+		wantHistory   int
+	}{
+		{name: "conflict", historyErrors: 1, wantHistory: 1},
+		{name: "failure", historyErrors: 100, wantHistory: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			historyErrors := tc.historyErrors
+			apiReader := k8stest.NewFakeClientBuilder(t).
+				WithObjects(di.DeepCopy(), job.DeepCopy(), run.DeepCopy(), lease.DeepCopy()).
+				WithStatusSubresource(&vahkanev1.DiscordInteraction{}, &vahkanev1.InteractionRun{}).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceUpdate: func(
+						ctx context.Context,
+						c client.Client,
+						subResourceName string,
+						obj client.Object,
+						opts ...client.SubResourceUpdateOption,
+					) error {
+						if _, ok := obj.(*vahkanev1.DiscordInteraction); ok && historyErrors > 0 {
+							historyErrors--
+							return k8serrors.NewConflict(vahkanev1.GroupVersion.WithResource("discordinteractions").GroupResource(), obj.GetName(), nil)
+						}
+						return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+					},
+				}).
+				Build()
+			// The cache has seen the DiscordInteraction but not the Job.
+			cache := k8stest.NewFakeClientBuilder(t).WithObjects(di.DeepCopy()).Build()
+			k8sClient := k8stest.NewStaleCacheClient(apiReader, cache)
+
+			if _, err := cancelJob(ctx, k8sClient, apiReader, &record.FakeRecorder{}, types.NamespacedName{}, client.ObjectKeyFromObject(job), req); err != nil {
+				t.Fatalf("cancelJob failed: %v", err)
+			}
+
+			var gotDI vahkanev1.DiscordInteraction
+			if err := apiReader.Get(ctx, client.ObjectKeyFromObject(di), &gotDI); err != nil {
+				t.Fatal(err)
+			}
+			if len(gotDI.Status.History) != tc.wantHistory {
+				t.Errorf("unexpected history: %v", gotDI.Status.History)
+			}
+			var gotRun vahkanev1.InteractionRun
+			if err := apiReader.Get(ctx, client.ObjectKeyFromObject(run), &gotRun); err != nil {
+				t.Fatal(err)
+			}
+			if gotRun.Status.Phase != vahkanev1.InteractionRunPhaseCancelled {
+				t.Errorf("the InteractionRun should be cancelled: %v", gotRun.Status.Phase)
+			}
+			var gotLease coordinationv1.Lease
+			if err := apiReader.Get(ctx, client.ObjectKeyFromObject(lease), &gotLease); err != nil {
+				t.Fatal(err)
+			}
+			if gotLease.Spec.HolderIdentity != nil {
+				t.Errorf("the job lock should be released: %s", *gotLease.Spec.HolderIdentity)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-logr/logr"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultGatewayURL is the URL of the Discord Gateway.
+const DefaultGatewayURL = "wss://gateway.discord.gg/?v=10&encoding=json"
+
+// cf. https://discord.com/developers/docs/topics/opcodes-and-status-codes#gateway-gateway-opcodes
+const (
+	gatewayOpDispatch       = 0
+	gatewayOpHeartbeat      = 1
+	gatewayOpIdentify       = 2
+	gatewayOpResume         = 6
+	gatewayOpReconnect      = 7
+	gatewayOpInvalidSession = 9
+	gatewayOpHello          = 10
+	gatewayOpHeartbeatACK   = 11
+)
+
+// cf. https://discord.com/developers/docs/topics/opcodes-and-status-codes#gateway-gateway-close-event-codes
+const (
+	gatewayCloseAuthenticationFailed websocket.StatusCode = 4004
+	gatewayCloseInvalidSeq           websocket.StatusCode = 4007
+	gatewayCloseSessionTimedOut      websocket.StatusCode = 4009
+	gatewayCloseInvalidShard         websocket.StatusCode = 4010
+	gatewayCloseShardingRequired     websocket.StatusCode = 4011
+	gatewayCloseInvalidAPIVersion    websocket.StatusCode = 4012
+	gatewayCloseInvalidIntents       websocket.StatusCode = 4013
+	gatewayCloseDisallowedIntents    websocket.StatusCode = 4014
+)
+
+const (
+	gatewayMinBackoff = time.Second
+	gatewayMaxBackoff = time.Minute
+
+	// gatewayReadLimit is the maximum size of a payload from the Gateway.
+	// READY lists every guild of the application, so it may be large.
+	gatewayReadLimit = 4 << 20
+)
+
+var (
+	errGatewayReconnect      = errors.New("reconnect requested by the gateway")
+	errGatewayInvalidSession = errors.New("invalid session")
+	errGatewayFatalClose     = errors.New("the gateway refused the connection")
+)
+
+// gatewayCloseAction tells what to do after the Gateway closes the connection
+// with code. The session can't be resumed if reidentify is true. The
+// connection is refused until the token or the configuration is fixed if
+// fatal is true.
+func gatewayCloseAction(code websocket.StatusCode) (reidentify, fatal bool) {
+	switch code {
+	case gatewayCloseInvalidSeq, gatewayCloseSessionTimedOut:
+		return true, false
+	case gatewayCloseAuthenticationFailed,
+		gatewayCloseInvalidShard,
+		gatewayCloseShardingRequired,
+		gatewayCloseInvalidAPIVersion,
+		gatewayCloseInvalidIntents,
+		gatewayCloseDisallowedIntents:
+		return true, true
+	}
+	return false, false
+}
+
+type gatewayPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// gatewayConn sends the payloads from the receiving loop and the heartbeat
+// loop. websocket.Conn serializes the concurrent writes.
+type gatewayConn struct {
+	ws *websocket.Conn
+}
+
+func (c *gatewayConn) send(ctx context.Context, op int, d interface{}) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return wsjson.Write(ctx, c.ws, gatewayPayload{Op: op, D: data})
+}
+
+// responseBuffer is an http.ResponseWriter that keeps the interaction
+// response so that it can be sent to the interaction callback endpoint.
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(int) {}
+
+// DiscordGatewayRunner receives the interactions of the default application
+// through the Discord Gateway instead of the HTTP interactions endpoint, and
+// responds to them through the interaction callback endpoint.
+type DiscordGatewayRunner struct {
+	interactionHandler
+	applications *controller.ApplicationResolver
+	gatewayURL   string
+	// handling tracks the interactions being responded to.
+	handling sync.WaitGroup
+
+	mu        sync.Mutex
+	sessionID string
+	resumeURL string
+	sequence  *int64
+}
+
+func NewDiscordGatewayRunner(
+	k8sClient client.Client,
+	apiReader client.Reader,
+	applications *controller.ApplicationResolver,
+	recorder record.EventRecorder,
+	auditSink audit.Sink,
+	workers WorkerPoolOptions,
+	logger logr.Logger,
+	gatewayURL string,
+) *DiscordGatewayRunner {
+	return &DiscordGatewayRunner{
+		interactionHandler: newInteractionHandler(k8sClient, apiReader, recorder, logger, auditSink, workers),
+		applications:       applications,
+		gatewayURL:         gatewayURL,
+	}
+}
+
+func (r *DiscordGatewayRunner) Start(ctx context.Context) error {
+	defer r.drainWorkers(r.handling.Wait)
+
+	backoff := gatewayMinBackoff
+	for {
+		established, err := r.runSession(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if established {
+			backoff = gatewayMinBackoff
+		}
+		if errors.Is(err, errGatewayReconnect) {
+			r.logger.Info("reconnecting to the discord gateway")
+			continue
+		}
+		if errors.Is(err, errGatewayFatalClose) {
+			// Retrying soon won't help, but the token may be rotated.
+			backoff = gatewayMaxBackoff
+		}
+		r.logger.Error(err, "discord gateway session ended", "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, gatewayMaxBackoff)
+	}
+}
+
+// NeedLeaderElection returns true because every Gateway session receives all
+// the interactions, so only one replica may connect.
+func (r *DiscordGatewayRunner) NeedLeaderElection() bool {
+	return true
+}
+
+// runSession connects to the Gateway and handles the events until the
+// connection is closed. It resumes the previous session if any. It reports
+// whether the session was established.
+func (r *DiscordGatewayRunner) runSession(ctx context.Context) (bool, error) {
+	// Resolve the application on every connection to use the latest token.
+	app, err := r.applications.Resolve(ctx, types.NamespacedName{})
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve Discord application: %w", err)
+	}
+
+	r.mu.Lock()
+	url := r.gatewayURL
+	if r.sessionID != "" && r.resumeURL != "" {
+		url = r.resumeURL
+	}
+	r.mu.Unlock()
+
+	ws, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the gateway: %w", err)
+	}
+	// The connection is dropped without a close frame, since closing it
+	// normally invalidates the session to be resumed.
+	defer func() {
+		_ = ws.CloseNow()
+	}()
+	ws.SetReadLimit(gatewayReadLimit)
+	conn := &gatewayConn{ws: ws}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var hello gatewayPayload
+	if err := r.receive(ctx, ws, &hello); err != nil {
+		return false, fmt.Errorf("failed to receive hello: %w", err)
+	}
+	if hello.Op != gatewayOpHello {
+		return false, fmt.Errorf("unexpected opcode instead of hello: %d", hello.Op)
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if err := json.Unmarshal(hello.D, &helloData); err != nil {
+		return false, fmt.Errorf("failed to parse hello: %w", err)
+	}
+
+	if err := r.identifyOrResume(ctx, conn, app.Token); err != nil {
+		return false, err
+	}
+
+	var acked atomic.Bool
+	acked.Store(true)
+	go r.heartbeat(ctx, conn, time.Duration(helloData.HeartbeatInterval)*time.Millisecond, &acked)
+
+	established := false
+	for {
+		var payload gatewayPayload
+		if err := r.receive(ctx, ws, &payload); err != nil {
+			return established, fmt.Errorf("failed to receive: %w", err)
+		}
+		if payload.S != nil {
+			r.mu.Lock()
+			r.sequence = payload.S
+			r.mu.Unlock()
+		}
+
+		switch payload.Op {
+		case gatewayOpDispatch:
+			switch payload.T {
+			case "READY":
+				var ready struct {
+					SessionID        string `json:"session_id"`
+					ResumeGatewayURL string `json:"resume_gateway_url"`
+				}
+				if err := json.Unmarshal(payload.D, &ready); err != nil {
+					return established, fmt.Errorf("failed to parse READY: %w", err)
+				}
+				r.mu.Lock()
+				r.sessionID = ready.SessionID
+				r.resumeURL = ""
+				if ready.ResumeGatewayURL != "" {
+					r.resumeURL = ready.ResumeGatewayURL + "/?v=10&encoding=json"
+				}
+				r.mu.Unlock()
+				established = true
+				r.logger.Info("connected to the discord gateway")
+
+			case "RESUMED":
+				established = true
+				r.logger.Info("resumed the discord gateway session")
+
+			case "INTERACTION_CREATE":
+				r.handling.Add(1)
+				go func() {
+					defer r.handling.Done()
+					r.handleGatewayInteraction(app, payload.D)
+				}()
+			}
+
+		case gatewayOpHeartbeat:
+			if err := conn.send(ctx, gatewayOpHeartbeat, r.lastSequence()); err != nil {
+				return established, fmt.Errorf("failed to send heartbeat: %w", err)
+			}
+
+		case gatewayOpReconnect:
+			return established, errGatewayReconnect
+
+		case gatewayOpInvalidSession:
+			var resumable bool
+			_ = json.Unmarshal(payload.D, &resumable)
+			if !resumable {
+				r.resetSession()
+			}
+			return established, errGatewayInvalidSession
+
+		case gatewayOpHeartbeatACK:
+			acked.Store(true)
+		}
+	}
+}
+
+// receive reads the next payload. If the Gateway has closed the connection,
+// the session is forgotten as its close code requires, and
+// errGatewayFatalClose is returned for the codes that refuse reconnecting.
+func (r *DiscordGatewayRunner) receive(ctx context.Context, ws *websocket.Conn, payload *gatewayPayload) error {
+	err := wsjson.Read(ctx, ws, payload)
+	if err == nil {
+		return nil
+	}
+	code := websocket.CloseStatus(err)
+	reidentify, fatal := gatewayCloseAction(code)
+	if reidentify {
+		r.resetSession()
+	}
+	if fatal {
+		return fmt.Errorf("%w: close code %d: %w", errGatewayFatalClose, code, err)
+	}
+	return err
+}
+
+// resetSession forgets the session, so that the next connection identifies
+// instead of resuming.
+func (r *DiscordGatewayRunner) resetSession() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessionID = ""
+	r.resumeURL = ""
+	r.sequence = nil
+}
+
+func (r *DiscordGatewayRunner) identifyOrResume(ctx context.Context, conn *gatewayConn, token string) error {
+	r.mu.Lock()
+	sessionID, sequence := r.sessionID, r.sequence
+	r.mu.Unlock()
+
+	if sessionID != "" {
+		// cf. https://discord.com/developers/docs/topics/gateway#resuming
+		if err := conn.send(ctx, gatewayOpResume, map[string]interface{}{
+			"token":      token,
+			"session_id": sessionID,
+			"seq":        sequence,
+		}); err != nil {
+			return fmt.Errorf("failed to resume: %w", err)
+		}
+		return nil
+	}
+
+	// cf. https://discord.com/developers/docs/topics/gateway#identifying
+	if err := conn.send(ctx, gatewayOpIdentify, map[string]interface{}{
+		"token": token,
+		// Interactions are delivered regardless of the intents.
+		"intents": 0,
+		"properties": map[string]string{
+			"os":      runtime.GOOS,
+			"browser": "vahkane",
+			"device":  "vahkane",
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to identify: %w", err)
+	}
+	return nil
+}
+
+// heartbeat sends heartbeats until ctx is done. It closes the connection if
+// the previous heartbeat was not acknowledged, so that the session is
+// resumed on a new connection.
+func (r *DiscordGatewayRunner) heartbeat(
+	ctx context.Context,
+	conn *gatewayConn,
+	interval time.Duration,
+	acked *atomic.Bool,
+) {
+	// The first heartbeat is sent after interval * jitter.
+	timer := time.NewTimer(time.Duration(rand.Float64() * float64(interval)))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		if !acked.Swap(false) {
+			r.logger.Info("discord gateway heartbeat was not acknowledged")
+			_ = conn.ws.CloseNow()
+			return
+		}
+		if err := conn.send(ctx, gatewayOpHeartbeat, r.lastSequence()); err != nil {
+			r.logger.Error(err, "failed to send heartbeat")
+			_ = conn.ws.CloseNow()
+			return
+		}
+	}
+}
+
+func (r *DiscordGatewayRunner) lastSequence() *int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sequence
+}
+
+// handleGatewayInteraction handles the interaction in the same way as
+// handleWebhook, and sends the response to the interaction callback
+// endpoint.
+func (r *DiscordGatewayRunner) handleGatewayInteraction(app *controller.Application, body []byte) {
+	// Discord requires a response within 3 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	interaction, err := discord.ParseInteraction(body)
+	if err != nil {
+		r.logger.Info("invalid interaction", "error", err.Error())
+		r.rejectGatewayInteraction(ctx, app, body)
+		return
+	}
+
+	w := &responseBuffer{header: http.Header{}}
+	responded := newResponseGate()
+	defer responded.open(false)
+	if _, err := r.handleInteraction(w, interaction, app, responded); err != nil {
+		if !errors.Is(err, errUnsupportedInteraction) {
+			r.logger.Error(err, "failed to handle interaction", "interaction_id", interaction.ID)
+		}
+		return
+	}
+
+	if err := app.Client.CreateInteractionResponse(
+		ctx,
+		interaction.ID,
+		interaction.Token,
+		w.body.Bytes(),
+	); err != nil {
+		r.logger.Error(err, "failed to respond to interaction", "interaction_id", interaction.ID)
+		return
+	}
+	responded.open(true)
+}
+
+// rejectGatewayInteraction responds to the invalid interaction with an
+// ephemeral error, if it has the ID and the token to respond with. Unlike the
+// webhook, the gateway has no status code to tell Discord of the failure.
+func (r *DiscordGatewayRunner) rejectGatewayInteraction(
+	ctx context.Context,
+	app *controller.Application,
+	body []byte,
+) {
+	// json.Unmarshal fills these even if the other fields have wrong types.
+	var interaction struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(body, &interaction)
+	if interaction.ID == "" || interaction.Token == "" {
+		return
+	}
+
+	w := &responseBuffer{header: http.Header{}}
+	if err := respondMessage(w, ":x: invalid interaction", true); err != nil {
+		r.logger.Error(err, "failed to build the response", "interaction_id", interaction.ID)
+		return
+	}
+	if err := app.Client.CreateInteractionResponse(
+		ctx,
+		interaction.ID,
+		interaction.Token,
+		w.body.Bytes(),
+	); err != nil {
+		r.logger.Error(err, "failed to respond to interaction", "interaction_id", interaction.ID)
+	}
+}
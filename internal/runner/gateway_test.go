@@ -0,0 +1,244 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-logr/logr"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+type interactionResponse struct {
+	interactionID, interactionToken string
+	response                        []byte
+}
+
+type callbackClient struct {
+	discord.Client
+	responses chan interactionResponse
+}
+
+func (c *callbackClient) CreateInteractionResponse(
+	_ context.Context,
+	interactionID, interactionToken string,
+	response []byte,
+) error {
+	c.responses <- interactionResponse{interactionID, interactionToken, response}
+	return nil
+}
+
+// fakeGatewayConn is a connection to the fake gateway. It acknowledges
+// heartbeats and passes the other payloads to received.
+type fakeGatewayConn struct {
+	ws       *websocket.Conn
+	received chan gatewayPayload
+	done     chan struct{}
+}
+
+func (c *fakeGatewayConn) send(t *testing.T, op int, s int64, eventName string, d interface{}) {
+	t.Helper()
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := gatewayPayload{Op: op, D: data, T: eventName}
+	if s != 0 {
+		payload.S = &s
+	}
+	if err := wsjson.Write(context.Background(), c.ws, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *fakeGatewayConn) receive(t *testing.T) gatewayPayload {
+	t.Helper()
+	select {
+	case payload := <-c.received:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a payload")
+	}
+	return gatewayPayload{}
+}
+
+func TestDiscordGatewayRunner(t *testing.T) {
+	conns := make(chan *fakeGatewayConn)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = ws.CloseNow()
+		}()
+		conn := &fakeGatewayConn{ws: ws, received: make(chan gatewayPayload, 10), done: make(chan struct{})}
+		go func() {
+			defer close(conn.done)
+			for {
+				var payload gatewayPayload
+				if err := wsjson.Read(context.Background(), ws, &payload); err != nil {
+					return
+				}
+				if payload.Op == gatewayOpHeartbeat {
+					_ = wsjson.Write(context.Background(), ws, gatewayPayload{Op: gatewayOpHeartbeatACK})
+					continue
+				}
+				conn.received <- payload
+			}
+		}()
+		conns <- conn
+		<-conn.done
+	}))
+	defer srv.Close()
+	gatewayURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	discordClient := &callbackClient{responses: make(chan interactionResponse, 1)}
+	runner := NewDiscordGatewayRunner(
+		nil,
+		nil,
+		controller.NewApplicationResolver(
+			nil,
+			&controller.Application{Token: "bot-token", Client: discordClient},
+			types.NamespacedName{},
+			nil,
+		),
+		&record.FakeRecorder{},
+		nil,
+		WorkerPoolOptions{},
+		logr.Discard(),
+		gatewayURL,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = runner.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// The first connection identifies.
+	conn := <-conns
+	conn.send(t, gatewayOpHello, 0, "", map[string]int{"heartbeat_interval": 50})
+	identify := conn.receive(t)
+	if identify.Op != gatewayOpIdentify {
+		t.Fatalf("unexpected opcode instead of identify: %d", identify.Op)
+	}
+	var identifyData struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(identify.D, &identifyData); err != nil {
+		t.Fatal(err)
+	}
+	if identifyData.Token != "bot-token" {
+		t.Errorf("unexpected token: %s", identifyData.Token)
+	}
+	conn.send(t, gatewayOpDispatch, 1, "READY", map[string]string{"session_id": "session"})
+
+	// Interactions are handled and responded through the callback endpoint.
+	conn.send(t, gatewayOpDispatch, 2, "INTERACTION_CREATE", map[string]interface{}{
+		"type":     3, // MESSAGE_COMPONENT
+		"id":       "interaction-id",
+		"token":    "interaction-token",
+		"guild_id": "guild",
+		"data":     map[string]string{"custom_id": "unknown"},
+	})
+	select {
+	case resp := <-discordClient.responses:
+		if resp.interactionID != "interaction-id" || resp.interactionToken != "interaction-token" {
+			t.Errorf("unexpected interaction: %s: %s", resp.interactionID, resp.interactionToken)
+		}
+		if !strings.Contains(string(resp.response), "unknown component") {
+			t.Errorf("unexpected response: %s", resp.response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the interaction response")
+	}
+
+	// Invalid interactions are rejected with an ephemeral error.
+	conn.send(t, gatewayOpDispatch, 3, "INTERACTION_CREATE", map[string]interface{}{
+		"type":  2, // APPLICATION_COMMAND
+		"id":    "invalid-id",
+		"token": "invalid-token",
+		"data":  map[string]interface{}{"name": "deploy", "options": []interface{}{map[string]interface{}{"name": "o", "value": []int{1}}}},
+	})
+	select {
+	case resp := <-discordClient.responses:
+		if resp.interactionID != "invalid-id" || resp.interactionToken != "invalid-token" {
+			t.Errorf("unexpected interaction: %s: %s", resp.interactionID, resp.interactionToken)
+		}
+		if !strings.Contains(string(resp.response), "invalid interaction") ||
+			!strings.Contains(string(resp.response), `"flags":64`) {
+			t.Errorf("unexpected response: %s", resp.response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the interaction response")
+	}
+
+	// Wait for heartbeats to be acknowledged a few times.
+	time.Sleep(200 * time.Millisecond)
+
+	// The session is resumed after the gateway requests reconnecting.
+	conn.send(t, gatewayOpReconnect, 0, "", nil)
+	conn = <-conns
+	conn.send(t, gatewayOpHello, 0, "", map[string]int{"heartbeat_interval": 50})
+	resume := conn.receive(t)
+	if resume.Op != gatewayOpResume {
+		t.Fatalf("unexpected opcode instead of resume: %d", resume.Op)
+	}
+	var resumeData struct {
+		Token     string `json:"token"`
+		SessionID string `json:"session_id"`
+		Seq       int64  `json:"seq"`
+	}
+	if err := json.Unmarshal(resume.D, &resumeData); err != nil {
+		t.Fatal(err)
+	}
+	if resumeData.Token != "bot-token" || resumeData.SessionID != "session" || resumeData.Seq != 3 {
+		t.Errorf("unexpected resume: %+v", resumeData)
+	}
+
+	// A new session is identified after the gateway closes the connection
+	// with a code that doesn't allow resuming.
+	_ = conn.ws.Close(gatewayCloseSessionTimedOut, "session timed out")
+	conn = <-conns
+	conn.send(t, gatewayOpHello, 0, "", map[string]int{"heartbeat_interval": 50})
+	if identify := conn.receive(t); identify.Op != gatewayOpIdentify {
+		t.Fatalf("unexpected opcode instead of identify: %d", identify.Op)
+	}
+}
+
+func TestGatewayCloseAction(t *testing.T) {
+	for _, tt := range []struct {
+		code       websocket.StatusCode
+		reidentify bool
+		fatal      bool
+	}{
+		{code: websocket.StatusCode(-1)},
+		{code: websocket.StatusGoingAway},
+		{code: 4000},
+		{code: gatewayCloseInvalidSeq, reidentify: true},
+		{code: gatewayCloseSessionTimedOut, reidentify: true},
+		{code: gatewayCloseAuthenticationFailed, reidentify: true, fatal: true},
+		{code: gatewayCloseInvalidShard, reidentify: true, fatal: true},
+		{code: gatewayCloseDisallowedIntents, reidentify: true, fatal: true},
+	} {
+		reidentify, fatal := gatewayCloseAction(tt.code)
+		if reidentify != tt.reidentify || fatal != tt.fatal {
+			t.Errorf("gatewayCloseAction(%d) = %v, %v", tt.code, reidentify, fatal)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	eventReasonHTTPActionSucceeded = "HTTPActionSucceeded"
+	eventReasonHTTPActionFailed    = "HTTPActionFailed"
+
+	// maxHTTPActionReplySize is the maximum size of the reply of the endpoint.
+	maxHTTPActionReplySize = 1 << 20
+
+	httpActionDefaultTimeout = 10 * time.Second
+	httpActionDefaultRetries = 2
+)
+
+// httpActionRequest is the body POSTed to the endpoint of the HTTP action.
+type httpActionRequest struct {
+	InteractionID      string                   `json:"interactionID"`
+	DiscordInteraction types.NamespacedName     `json:"discordInteraction"`
+	Action             string                   `json:"action"`
+	GuildID            string                   `json:"guildID"`
+	ChannelID          string                   `json:"channelID"`
+	User               discord.User             `json:"user"`
+	Options            map[string]interface{}   `json:"options"`
+	Data               *discord.InteractionData `json:"data"`
+}
+
+// httpActionReply is the reply of the endpoint relayed to Discord.
+type httpActionReply struct {
+	Content   string            `json:"content"`
+	Embeds    []json.RawMessage `json:"embeds,omitempty"`
+	Ephemeral bool              `json:"ephemeral"`
+}
+
+// errRetryable marks the errors of the requests that may succeed if retried.
+var errRetryable = errors.New("retryable")
+
+// handleHTTPAction defers the response, calls the endpoint of the HTTP action
+// in the background and relays its reply as the follow-up message.
+func (r *interactionHandler) handleHTTPAction(
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	app *controller.Application,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) error {
+	spec := action.ActionInline.HTTP
+	timeout, retries := httpActionDefaultTimeout, httpActionDefaultRetries
+	if spec.TimeoutSeconds > 0 {
+		timeout = time.Duration(spec.TimeoutSeconds) * time.Second
+	}
+	if spec.Retries != nil {
+		retries = int(*spec.Retries)
+	}
+	deferredEphemeral := action.Ephemeral != nil && action.Ephemeral.Queued
+
+	// Leave time for the backoff between the attempts and the follow-up, but
+	// not beyond the lifetime of the token used to send it.
+	taskTimeout := min(
+		time.Duration(retries+1)*timeout+time.Duration(retries*retries)*time.Second+5*time.Second,
+		discord.InteractionTokenLifetime,
+	)
+	err := r.workers.submitWithTimeout(taskTimeout, func(ctx context.Context) {
+		entry := newAuditEntry(req, di, action, audit.DecisionExecuted)
+		defer r.writeAudit(entry)
+
+		// The endpoint is not called unless the invoker is told that the
+		// action is being run.
+		if !req.responded.wait(ctx) {
+			r.logger.Info("skip interaction whose response was not sent", "interaction_id", req.ID)
+			entry.Decision, entry.Reason = audit.DecisionFailed, errResponseNotSent.Error()
+			return
+		}
+
+		reply, err := callHTTPAction(ctx, r.apiReader, di, action, req, timeout, retries)
+		if err != nil {
+			entry.Result, entry.Reason = string(vahkanev1.RunResultFailed), err.Error()
+			r.logger.Error(err, "failed to call the HTTP action", "action.Name", action.Name)
+			r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonHTTPActionFailed,
+				"Action %s by user %s failed: %v", action.Name, req.Invoker().ID, err)
+			reply := &httpActionReply{
+				Content:   ":x: failed to run the action",
+				Ephemeral: controller.IsResultEphemeral(action, vahkanev1.RunResultFailed),
+			}
+			if err := sendHTTPActionReply(ctx, app, req.Token, reply, deferredEphemeral); err != nil {
+				r.logger.Error(err, "failed to send followup message", "message", reply.Content)
+			}
+			return
+		}
+		entry.Result = string(vahkanev1.RunResultSucceeded)
+		r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonHTTPActionSucceeded,
+			"Action %s by user %s succeeded", action.Name, req.Invoker().ID)
+
+		if controller.IsResultEphemeral(action, vahkanev1.RunResultSucceeded) {
+			reply.Ephemeral = true
+		}
+		if err := sendHTTPActionReply(ctx, app, req.Token, reply, deferredEphemeral); err != nil {
+			r.logger.Error(err, "failed to send followup message", "action.Name", action.Name)
+		}
+	})
+	if err != nil {
+		return r.refuseBusy(w, req, di, action, err)
+	}
+
+	return respondDeferred(w, deferredEphemeral)
+}
+
+// sendHTTPActionReply sends the reply of the endpoint as the follow-up
+// message. If the deferred response is ephemeral, so is the reply.
+func sendHTTPActionReply(
+	ctx context.Context,
+	app *controller.Application,
+	interactionToken string,
+	reply *httpActionReply,
+	deferredEphemeral bool,
+) error {
+	// The endpoint may echo the options given by the invoker.
+	message := map[string]interface{}{
+		"content":          discord.TruncateContent(reply.Content),
+		"allowed_mentions": discord.NoMentions(),
+	}
+	if reply.Content == "" && len(reply.Embeds) == 0 {
+		message["content"] = ":ok: done"
+	}
+	if len(reply.Embeds) > 0 {
+		message["embeds"] = reply.Embeds
+	}
+	if reply.Ephemeral && !deferredEphemeral {
+		// The first follow-up replaces the public deferred response, which
+		// ignores the flags. Delete it so that the follow-up is a new message.
+		if err := app.Client.DeleteOriginalResponse(ctx, interactionToken); err != nil {
+			return fmt.Errorf("failed to delete the original response: %w", err)
+		}
+		message["flags"] = discord.MessageFlagEphemeral
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return app.Client.SendFollowupMessageJSON(ctx, interactionToken, body)
+}
+
+// callHTTPAction POSTs the context of the interaction to the endpoint and
+// returns its reply. Failed requests are retried with backoff.
+func callHTTPAction(
+	ctx context.Context,
+	apiReader client.Reader,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	req *requestApplicationCommand,
+	timeout time.Duration,
+	retries int,
+) (*httpActionReply, error) {
+	spec := action.ActionInline.HTTP
+
+	var signingKey []byte
+	if ref := spec.SigningSecretRef; ref != nil {
+		var secret corev1.Secret
+		if err := apiReader.Get(
+			ctx,
+			types.NamespacedName{Name: ref.Name, Namespace: di.GetNamespace()},
+			&secret,
+		); err != nil {
+			return nil, fmt.Errorf("failed to get the signing Secret: %w", err)
+		}
+		key, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in the signing Secret: %s", ref.Key, ref.Name)
+		}
+		signingKey = key
+	}
+
+	body, err := json.Marshal(&httpActionRequest{
+		InteractionID:      req.ID,
+		DiscordInteraction: types.NamespacedName{Namespace: di.GetNamespace(), Name: di.GetName()},
+		Action:             action.Name,
+		GuildID:            req.GuildID,
+		ChannelID:          req.ChannelID,
+		User:               *req.Invoker(),
+		Options:            req.optionValues(),
+		Data:               req.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		reply, err := postHTTPAction(ctx, spec.URL, req.ID, body, signingKey, timeout)
+		if err == nil || !errors.Is(err, errRetryable) || attempt >= retries {
+			return reply, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+func postHTTPAction(
+	ctx context.Context,
+	url, interactionID string,
+	body, signingKey []byte,
+	timeout time.Duration,
+) (*httpActionReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", "vahkane")
+	// The retries of a request share the key so that the endpoint can tell
+	// them from new invocations.
+	req.Header.Set("Idempotency-Key", interactionID)
+	if signingKey != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Vahkane-Timestamp", timestamp)
+		req.Header.Set("X-Vahkane-Signature", "sha256="+signHTTPAction(signingKey, timestamp, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errRetryable, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPActionReplySize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read the reply: %w", errRetryable, err)
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: unexpected status: %s", errRetryable, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status: %s: %s", resp.Status, respBody)
+	}
+
+	var reply httpActionReply
+	if len(bytes.TrimSpace(respBody)) == 0 {
+		return &reply, nil
+	}
+	if err := json.Unmarshal(respBody, &reply); err != nil {
+		return nil, fmt.Errorf("failed to parse the reply: %w", err)
+	}
+	return &reply, nil
+}
+
+// signHTTPAction returns the hex-encoded HMAC-SHA256 of the timestamp and the
+// body.
+func signHTTPAction(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
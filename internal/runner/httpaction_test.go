@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type followupRecorder struct {
+	discord.Client
+	deleted  bool
+	messages []map[string]interface{}
+}
+
+func (c *followupRecorder) DeleteOriginalResponse(context.Context, string) error {
+	c.deleted = true
+	return nil
+}
+
+func (c *followupRecorder) SendFollowupMessageJSON(_ context.Context, _ string, message []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(message, &m); err != nil {
+		return err
+	}
+	c.messages = append(c.messages, m)
+	return nil
+}
+
+func TestCallHTTPAction(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.Header.Get("Idempotency-Key") != "1234" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		timestamp := req.Header.Get("X-Vahkane-Timestamp")
+		if req.Header.Get("X-Vahkane-Signature") != "sha256="+signHTTPAction([]byte("secret"), timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload httpActionRequest
+		if err := json.Unmarshal(body, &payload); err != nil || payload.User.ID != "user-id" ||
+			payload.Action != "action" || payload.Options["target"] != "web" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"content":"hello","embeds":[{"title":"t"}],"ephemeral":true}`))
+	}))
+	defer srv.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "signing", Namespace: "ns"},
+		Data:       map[string][]byte{"key": []byte("secret")},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(secret).Build()
+
+	di := &vahkanev1.DiscordInteraction{ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"}}
+	action := &vahkanev1.DiscordInteractionAction{
+		Name: "action",
+		ActionInline: vahkanev1.DiscordInteractionActionInline{
+			HTTP: &vahkanev1.DiscordInteractionActionHTTP{
+				URL: srv.URL,
+				SigningSecretRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "signing"},
+					Key:                  "key",
+				},
+			},
+		},
+	}
+	req := &requestApplicationCommand{Interaction: discord.Interaction{
+		ID:   "1234",
+		Data: &discord.InteractionData{Options: []discord.InteractionDataOption{{Name: "target", Value: "web"}}},
+		User: &discord.User{ID: "user-id", Username: "user"},
+	}}
+
+	ctx := context.Background()
+	if _, err := callHTTPAction(ctx, k8sClient, di, action, req, time.Second, 0); err == nil {
+		t.Fatal("callHTTPAction should fail without retries")
+	}
+	reply, err := callHTTPAction(ctx, k8sClient, di, action, req, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "hello" || len(reply.Embeds) != 1 || !reply.Ephemeral {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+
+	discordClient := &followupRecorder{}
+	if err := sendHTTPActionReply(ctx, &controller.Application{Client: discordClient}, "token", reply, false); err != nil {
+		t.Fatal(err)
+	}
+	if !discordClient.deleted {
+		t.Error("the deferred response should be deleted for an ephemeral reply")
+	}
+	if len(discordClient.messages) != 1 || discordClient.messages[0]["flags"] != float64(64) ||
+		discordClient.messages[0]["content"] != "hello" {
+		t.Errorf("unexpected followup messages: %v", discordClient.messages)
+	}
+
+	long := &httpActionReply{Content: strings.Repeat("x", discord.MaxContentLength+1)}
+	if err := sendHTTPActionReply(ctx, &controller.Application{Client: discordClient}, "token", long, false); err != nil {
+		t.Fatal(err)
+	}
+	if content := discordClient.messages[1]["content"].(string); len(content) > discord.MaxContentLength {
+		t.Errorf("the content should be truncated: %d", len(content))
+	}
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	eventReasonOperationSucceeded = "OperationSucceeded"
+	eventReasonOperationFailed    = "OperationFailed"
+)
+
+// errInvalidOptions is returned if the command options can't be used for the
+// operation. Its message is shown to the invoker.
+var errInvalidOptions = errors.New("invalid options")
+
+// handleOperationAction runs the operation action and responds with its
+// result.
+func (r *interactionHandler) handleOperationAction(
+	ctx context.Context,
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) error {
+	entry := newAuditEntry(req, di, action, audit.DecisionExecuted)
+	defer r.writeAudit(entry)
+
+	msg, err := runOperation(ctx, r.k8sClient, di.GetNamespace(), action.ActionInline.Operation, req.optionValues())
+	if err != nil {
+		entry.Result, entry.Reason = string(vahkanev1.RunResultFailed), err.Error()
+		r.logger.Error(err, "failed to run operation", "action.Name", action.Name)
+		r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonOperationFailed,
+			"Action %s by user %s failed: %v", action.Name, req.Invoker().ID, err)
+		switch {
+		case errors.Is(err, errInvalidOptions):
+			return respondMessage(w, ":x: "+err.Error(), true)
+		case k8serrors.IsNotFound(err):
+			return respondMessage(w, ":x: the target was not found", true)
+		default:
+			return respondMessage(w, ":x: failed to run the action", true)
+		}
+	}
+	entry.Result = string(vahkanev1.RunResultSucceeded)
+	r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonOperationSucceeded,
+		"Action %s by user %s: %s", action.Name, req.Invoker().ID, msg)
+	return respondMessage(w, ":ok: "+msg, controller.IsResultEphemeral(action, vahkanev1.RunResultSucceeded))
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;patch
+
+// runOperation patches the target of the operation in the namespace and
+// returns the message describing the result.
+func runOperation(
+	ctx context.Context,
+	k8sClient client.Client,
+	namespace string,
+	op *vahkanev1.DiscordInteractionActionOperation,
+	options map[string]interface{},
+) (string, error) {
+	name := op.Target.Name
+	if op.Target.NameOption != "" {
+		name, _ = options[op.Target.NameOption].(string)
+		if name == "" {
+			return "", fmt.Errorf("%w: option %s is required", errInvalidOptions, op.Target.NameOption)
+		}
+		if len(op.Target.AllowedNames) > 0 && !slices.Contains(op.Target.AllowedNames, name) {
+			return "", fmt.Errorf("%w: %s %s is not allowed", errInvalidOptions, op.Target.Kind, name)
+		}
+	}
+
+	var patch map[string]interface{}
+	var msg string
+	switch op.Type {
+	case vahkanev1.OperationTypeRolloutRestart:
+		// cf. kubectl rollout restart
+		patch = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"annotations": map[string]string{
+							"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+						},
+					},
+				},
+			},
+		}
+		msg = fmt.Sprintf("restarted %s %s", op.Target.Kind, name)
+
+	case vahkanev1.OperationTypeScale:
+		var replicas int64
+		if op.Replicas != nil {
+			replicas = int64(*op.Replicas)
+		} else {
+			value, ok := options[op.ReplicasOption].(float64)
+			if !ok || value < 0 || value != math.Trunc(value) {
+				return "", fmt.Errorf("%w: option %s must be a non-negative integer", errInvalidOptions, op.ReplicasOption)
+			}
+			replicas = int64(value)
+		}
+		patch = map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": replicas},
+		}
+		msg = fmt.Sprintf("scaled %s %s to %d replicas", op.Target.Kind, name, replicas)
+
+	case vahkanev1.OperationTypeAnnotate:
+		if op.Annotation == nil {
+			return "", errors.New("annotation is not specified")
+		}
+		value := op.Annotation.Value
+		if op.Annotation.ValueOption != "" {
+			option, ok := options[op.Annotation.ValueOption]
+			if !ok {
+				return "", fmt.Errorf("%w: option %s is required", errInvalidOptions, op.Annotation.ValueOption)
+			}
+			value = fmt.Sprint(option)
+		} else if value == "" {
+			value = time.Now().Format(time.RFC3339Nano)
+		}
+		patch = map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{op.Annotation.Key: value},
+			},
+		}
+		msg = fmt.Sprintf("annotated %s %s with %s=%s", op.Target.Kind, name, op.Annotation.Key, value)
+
+	default:
+		return "", fmt.Errorf("unknown operation type: %s", op.Type)
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return "", err
+	}
+	apiVersion := op.Target.APIVersion
+	if apiVersion == "" {
+		apiVersion = "apps/v1"
+	}
+	var obj unstructured.Unstructured
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(op.Target.Kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	if err := k8sClient.Patch(ctx, &obj, client.RawPatch(types.MergePatchType, data)); err != nil {
+		return "", fmt.Errorf("failed to patch %s: %s: %w", op.Target.Kind, name, err)
+	}
+	return msg, nil
+}
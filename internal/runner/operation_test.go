@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func TestRunOperation(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To[int32](1)},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(deploy).Build()
+	ctx := context.Background()
+	get := func() *appsv1.Deployment {
+		t.Helper()
+		var deploy appsv1.Deployment
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: "ns"}, &deploy); err != nil {
+			t.Fatal(err)
+		}
+		return &deploy
+	}
+
+	target := vahkanev1.DiscordInteractionActionOperationTarget{
+		Kind:         "Deployment",
+		NameOption:   "target",
+		AllowedNames: []string{"web", "api"},
+	}
+
+	if _, err := runOperation(ctx, k8sClient, "ns", &vahkanev1.DiscordInteractionActionOperation{
+		Type:   vahkanev1.OperationTypeRolloutRestart,
+		Target: target,
+	}, map[string]interface{}{"target": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if get().Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Error("the Deployment should be restarted")
+	}
+
+	scale := &vahkanev1.DiscordInteractionActionOperation{
+		Type:           vahkanev1.OperationTypeScale,
+		Target:         target,
+		ReplicasOption: "replicas",
+	}
+	msg, err := runOperation(ctx, k8sClient, "ns", scale, map[string]interface{}{"target": "web", "replicas": float64(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "scaled Deployment web to 3 replicas" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if *get().Spec.Replicas != 3 {
+		t.Errorf("unexpected replicas: %d", *get().Spec.Replicas)
+	}
+	if _, err := runOperation(ctx, k8sClient, "ns", scale, map[string]interface{}{"target": "web", "replicas": float64(-1)}); !errors.Is(err, errInvalidOptions) {
+		t.Errorf("negative replicas should be refused: %v", err)
+	}
+	if _, err := runOperation(ctx, k8sClient, "ns", scale, map[string]interface{}{"target": "db", "replicas": float64(1)}); !errors.Is(err, errInvalidOptions) {
+		t.Errorf("a target not in allowedNames should be refused: %v", err)
+	}
+	if _, err := runOperation(ctx, k8sClient, "ns", scale, map[string]interface{}{"target": "api", "replicas": float64(1)}); !k8serrors.IsNotFound(err) {
+		t.Errorf("a missing target should be reported: %v", err)
+	}
+
+	if _, err := runOperation(ctx, k8sClient, "ns", &vahkanev1.DiscordInteractionActionOperation{
+		Type:       vahkanev1.OperationTypeAnnotate,
+		Target:     vahkanev1.DiscordInteractionActionOperationTarget{Kind: "Deployment", Name: "web"},
+		Annotation: &vahkanev1.DiscordInteractionActionOperationAnnotation{Key: "example.com/reconcile"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if get().Annotations["example.com/reconcile"] == "" {
+		t.Error("the Deployment should be annotated")
+	}
+}
@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -8,10 +9,22 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+var errNoActionMatched = errors.New("no action matched")
+
+// matchActions returns the first action whose pattern matches the data of the
+// command. The patterns are matched against the data as sent by Discord so
+// that they can refer to any of its fields.
 func matchActions(
 	actions []vahkanev1.DiscordInteractionAction,
-	data interface{},
+	rawData json.RawMessage,
 ) (*vahkanev1.DiscordInteractionAction, error) {
+	var data interface{}
+	if len(rawData) > 0 {
+		if err := json.Unmarshal(rawData, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode the command data: %w", err)
+		}
+	}
+
 	for _, action := range actions {
 		var pattern interface{}
 		if err := yaml.Unmarshal([]byte(action.Pattern), &pattern); err != nil {
@@ -21,7 +34,7 @@ func matchActions(
 			return &action, nil
 		}
 	}
-	return nil, errors.New("not found")
+	return nil, errNoActionMatched
 }
 
 func doesPatternMatch(pattern interface{}, data interface{}) bool {
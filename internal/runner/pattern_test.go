@@ -1,8 +1,11 @@
 package runner
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
 	"sigs.k8s.io/yaml"
 )
 
@@ -50,3 +53,31 @@ a:
 		}
 	}
 }
+
+func TestMatchActions(t *testing.T) {
+	actions := []vahkanev1.DiscordInteractionAction{
+		{Name: "staging", Pattern: `{name: deploy, options: [{name: env, value: staging}]}`},
+		{Name: "deploy", Pattern: `{name: deploy}`},
+	}
+	data := `{"id": "command-id", "name": "deploy", "type": 1, "options": [{"name": "env", "type": 3, "value": "staging"}]}`
+	if action, err := matchActions(actions, json.RawMessage(data)); err != nil || action.Name != "staging" {
+		t.Errorf("unexpected action: %v: %v", action, err)
+	}
+
+	data = `{"id": "command-id", "name": "deploy", "type": 1, "options": [{"name": "env", "type": 3, "value": "prod"}]}`
+	if action, err := matchActions(actions, json.RawMessage(data)); err != nil || action.Name != "deploy" {
+		t.Errorf("unexpected action: %v: %v", action, err)
+	}
+
+	data = `{"id": "command-id", "name": "rollback", "type": 1}`
+	if _, err := matchActions(actions, json.RawMessage(data)); !errors.Is(err, errNoActionMatched) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The fields unknown to discord.InteractionData can be matched too.
+	actions = []vahkanev1.DiscordInteractionAction{{Name: "new", Pattern: `{name: deploy, new_field: x}`}}
+	data = `{"name": "deploy", "new_field": "x"}`
+	if action, err := matchActions(actions, json.RawMessage(data)); err != nil || action.Name != "new" {
+		t.Errorf("unexpected action: %v: %v", action, err)
+	}
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+)
+
+// rateLimitSweepInterval is the interval to forget the keys whose invocations
+// have all expired.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimit is a limit of invocations identified by key.
+type rateLimit struct {
+	key    string
+	limit  int
+	window time.Duration
+}
+
+type rateLimitEntry struct {
+	window time.Duration
+	times  []time.Time
+}
+
+// rateLimiter counts the invocations in sliding windows. It is safe for
+// concurrent use by the goroutines handling interactions.
+type rateLimiter struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{entries: map[string]*rateLimitEntry{}}
+}
+
+// allow records an invocation at now if it exceeds none of the limits.
+// Otherwise, it returns how long to wait until the invocation is allowed.
+func (l *rateLimiter) allow(now time.Time, limits ...rateLimit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for key, entry := range l.entries {
+			if len(entry.times) == 0 || now.Sub(entry.times[len(entry.times)-1]) >= entry.window {
+				delete(l.entries, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	var wait time.Duration
+	for _, limit := range limits {
+		entry, ok := l.entries[limit.key]
+		if !ok {
+			continue
+		}
+		entry.window = limit.window
+		expired := 0
+		for expired < len(entry.times) && now.Sub(entry.times[expired]) >= limit.window {
+			expired++
+		}
+		entry.times = entry.times[expired:]
+		if len(entry.times) >= limit.limit {
+			wait = max(wait, entry.times[len(entry.times)-limit.limit].Add(limit.window).Sub(now))
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, limit := range limits {
+		entry, ok := l.entries[limit.key]
+		if !ok {
+			entry = &rateLimitEntry{window: limit.window}
+			l.entries[limit.key] = entry
+		}
+		entry.times = append(entry.times, now)
+	}
+	return true, 0
+}
+
+// actionRateLimits returns the limits of the action invoked by the user.
+func actionRateLimits(
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	userID string,
+) []rateLimit {
+	spec := action.RateLimit
+	if spec == nil || spec.Window.Duration <= 0 {
+		return nil
+	}
+	prefix := fmt.Sprintf("%s/%s/%s", di.GetNamespace(), di.GetName(), action.Name)
+	limits := []rateLimit{}
+	if spec.PerUser != nil {
+		limits = append(limits, rateLimit{
+			key:    prefix + "/user/" + userID,
+			limit:  int(*spec.PerUser),
+			window: spec.Window.Duration,
+		})
+	}
+	if spec.Guild != nil {
+		limits = append(limits, rateLimit{
+			key:    prefix + "/guild",
+			limit:  int(*spec.Guild),
+			window: spec.Window.Duration,
+		})
+	}
+	return limits
+}
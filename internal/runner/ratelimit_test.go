@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestRateLimiter(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{ObjectMeta: metav1.ObjectMeta{Name: "di", Namespace: "ns"}}
+	action := &vahkanev1.DiscordInteractionAction{
+		Name: "rebuild",
+		RateLimit: &vahkanev1.DiscordInteractionActionRateLimit{
+			PerUser: ptr.To[int32](2),
+			Guild:   ptr.To[int32](3),
+			Window:  metav1.Duration{Duration: time.Minute},
+		},
+	}
+	limiter := newRateLimiter()
+	now := time.Now()
+
+	allow := func(userID string, at time.Time) (bool, time.Duration) {
+		return limiter.allow(at, actionRateLimits(di, action, userID)...)
+	}
+
+	if ok, _ := allow("alice", now); !ok {
+		t.Error("the first invocation should be allowed")
+	}
+	if ok, _ := allow("alice", now.Add(20*time.Second)); !ok {
+		t.Error("the second invocation should be allowed")
+	}
+	ok, wait := allow("alice", now.Add(30*time.Second))
+	if ok || wait != 30*time.Second {
+		t.Errorf("the third invocation by the user should be refused: %v: %v", ok, wait)
+	}
+
+	// The refused invocation is not counted against the guild limit.
+	if ok, _ := allow("bob", now.Add(30*time.Second)); !ok {
+		t.Error("an invocation by another user should be allowed")
+	}
+	ok, wait = allow("carol", now.Add(40*time.Second))
+	if ok || wait != 20*time.Second {
+		t.Errorf("an invocation over the guild limit should be refused: %v: %v", ok, wait)
+	}
+
+	if ok, _ := allow("alice", now.Add(time.Minute)); !ok {
+		t.Error("an invocation after the window should be allowed")
+	}
+
+	// Expired keys are forgotten.
+	allow("dave", now.Add(10*time.Minute))
+	if len(limiter.entries) != 2 {
+		t.Errorf("unexpected entries: %v", limiter.entries)
+	}
+
+	// Actions without limits are always allowed.
+	if ok, _ := limiter.allow(now, actionRateLimits(di, &vahkanev1.DiscordInteractionAction{}, "alice")...); !ok {
+		t.Error("an action without limits should be allowed")
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	limiter := newRateLimiter()
+	limit := rateLimit{key: "key", limit: 10, window: time.Hour}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := limiter.allow(now, limit); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Errorf("unexpected number of allowed invocations: %d", allowed)
+	}
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// replyTemplateData is the data passed to the template of the reply action.
+type replyTemplateData struct {
+	User      discord.User
+	GuildID   string
+	ChannelID string
+	Options   map[string]interface{}
+	Value     string
+}
+
+// handleReplyAction responds with the message of the reply action.
+func (r *interactionHandler) handleReplyAction(
+	ctx context.Context,
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) error {
+	entry := newAuditEntry(req, di, action, audit.DecisionExecuted)
+	defer r.writeAudit(entry)
+
+	content, err := renderReply(ctx, r.apiReader, di.GetNamespace(), action.ActionInline.Reply, req)
+	if err != nil {
+		entry.Result, entry.Reason = string(vahkanev1.RunResultFailed), err.Error()
+		r.logger.Error(err, "failed to render reply", "action.Name", action.Name)
+		return respondMessage(w, ":x: failed to run the action", true)
+	}
+	entry.Result = string(vahkanev1.RunResultSucceeded)
+	return respondMessage(w, content, controller.IsResultEphemeral(action, vahkanev1.RunResultSucceeded))
+}
+
+// renderReply returns the message of the reply action for the request.
+func renderReply(
+	ctx context.Context,
+	apiReader client.Reader,
+	namespace string,
+	reply *vahkanev1.DiscordInteractionActionReply,
+	req *requestApplicationCommand,
+) (string, error) {
+	data := replyTemplateData{
+		User:      *req.Invoker(),
+		GuildID:   req.GuildID,
+		ChannelID: req.ChannelID,
+		Options:   req.optionValues(),
+	}
+
+	if ref := reply.ConfigMapKeyRef; ref != nil {
+		var cm corev1.ConfigMap
+		// ConfigMaps are not cached to avoid watching all of them.
+		if err := apiReader.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, &cm); err != nil {
+			return "", fmt.Errorf("failed to get ConfigMap: %w", err)
+		}
+		value, ok := cm.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in ConfigMap: %s", ref.Key, ref.Name)
+		}
+		data.Value = value
+	}
+
+	if reply.Content == "" {
+		return data.Value, nil
+	}
+	tmpl, err := controller.ParseReplyTemplate(reply.Content)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the reply template: %w", err)
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, &data); err != nil {
+		return "", fmt.Errorf("failed to render the reply template: %w", err)
+	}
+	return buf.String(), nil
+}
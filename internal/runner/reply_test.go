@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"testing"
+
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderReply(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "oncall", Namespace: "ns"},
+		Data:       map[string]string{"primary": "<@1234>"},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).WithObjects(cm).Build()
+
+	req := &requestApplicationCommand{Interaction: discord.Interaction{
+		GuildID: "guild",
+		Data: &discord.InteractionData{
+			Name:    "runbook",
+			Options: []discord.InteractionDataOption{{Name: "topic", Value: "dns"}},
+		},
+		Member: &discord.Member{User: &discord.User{ID: "user-id", Username: "user"}},
+	}}
+	ref := &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "oncall"},
+		Key:                  "primary",
+	}
+
+	tests := []struct {
+		name     string
+		reply    vahkanev1.DiscordInteractionActionReply
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "static",
+			reply:    vahkanev1.DiscordInteractionActionReply{Content: "https://example.com/runbook"},
+			expected: "https://example.com/runbook",
+		},
+		{
+			name:     "template",
+			reply:    vahkanev1.DiscordInteractionActionReply{Content: "{{.User.Username}}: https://example.com/runbook/{{.Options.topic}}"},
+			expected: "user: https://example.com/runbook/dns",
+		},
+		{
+			name:     "configmap",
+			reply:    vahkanev1.DiscordInteractionActionReply{ConfigMapKeyRef: ref},
+			expected: "<@1234>",
+		},
+		{
+			name:     "configmap in template",
+			reply:    vahkanev1.DiscordInteractionActionReply{Content: "on call: {{.Value}}", ConfigMapKeyRef: ref},
+			expected: "on call: <@1234>",
+		},
+		{
+			name: "missing key",
+			reply: vahkanev1.DiscordInteractionActionReply{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "oncall"},
+				Key:                  "secondary",
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid template",
+			reply:   vahkanev1.DiscordInteractionActionReply{Content: "{{.User"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderReply(context.Background(), k8sClient, "ns", &tt.reply, req)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("renderReply should fail: %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("unexpected reply: %s", got)
+			}
+		})
+	}
+}
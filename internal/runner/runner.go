@@ -8,53 +8,132 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
 	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
 	"github.com/ushitora-anqou/vahkane/internal/controller"
 	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
-	annotKeyDiscordInteraction = "vahkane.anqou.net/discord-interaction"
-	annotKeyAction             = "vahkane.anqou.net/action"
+	eventReasonActionMatched   = "ActionMatched"
+	eventReasonNoActionMatched = "NoActionMatched"
+	eventReasonAlreadyRunning  = "AlreadyRunning"
+	eventReasonForbidden       = "Forbidden"
+	eventReasonJobCreated      = "JobCreated"
+	eventReasonJobCancelled    = "JobCancelled"
+	eventReasonRateLimited     = "RateLimited"
+	eventReasonTooManyJobs     = "TooManyJobs"
+	eventReasonInvalidOptions  = "InvalidOptions"
 )
 
+var (
+	errUnsupportedInteraction = errors.New("unsupported interaction")
+	errResponseNotSent        = errors.New("the response to the interaction was not sent")
+	errNoDiscordInteraction   = errors.New("no DiscordInteraction serves the guild")
+)
+
+// webhookShutdownTimeout is how long the webhook server waits for the requests
+// being handled on shutdown. Discord requires responses within 3 seconds.
+const webhookShutdownTimeout = 5 * time.Second
+
+// interactionHandler handles the interactions received by any transport.
+type interactionHandler struct {
+	k8sClient client.Client
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	logger    logr.Logger
+	limiter   *rateLimiter
+	audit     audit.Sink
+	workers   *workerPool
+}
+
+func newInteractionHandler(
+	k8sClient client.Client,
+	apiReader client.Reader,
+	recorder record.EventRecorder,
+	logger logr.Logger,
+	auditSink audit.Sink,
+	workers WorkerPoolOptions,
+) interactionHandler {
+	if auditSink == nil {
+		auditSink = audit.Discard
+	}
+	return interactionHandler{
+		k8sClient: k8sClient,
+		apiReader: apiReader,
+		recorder:  recorder,
+		logger:    logger,
+		limiter:   newRateLimiter(),
+		audit:     auditSink,
+		workers:   newWorkerPool(workers),
+	}
+}
+
+// drainWorkers waits for the interactions being processed in the background
+// to finish, for DrainTimeout at most. handling waits for the interactions
+// still being handled, which may queue tasks; it may be nil. It must be
+// called after no more interactions are received.
+func (r *interactionHandler) drainWorkers(handling func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.workers.opts.DrainTimeout)
+	defer cancel()
+
+	r.logger.Info("draining interaction workers")
+	if handling != nil {
+		if err := waitContext(ctx, handling); err != nil {
+			r.logger.Error(err, "failed to wait for the interactions being handled")
+		}
+	}
+	if err := r.workers.drain(ctx); err != nil {
+		r.logger.Error(err, "failed to drain interaction workers")
+	}
+}
+
+// DiscordWebhookServerRunner serves the interactions of the default
+// application at /webhook and those of each DiscordApplication at
+// /webhook/<namespace>/<name>.
 type DiscordWebhookServerRunner struct {
-	k8sClient             client.Client
-	discordClient         discord.Client
-	logger                logr.Logger
-	publicKey             ed25519.PublicKey
-	listenAddr, namespace string
+	interactionHandler
+	applications *controller.ApplicationResolver
+	listenAddr   string
+	listening    atomic.Bool
 }
 
 func NewDiscordWebhookServerRunner(
 	k8sClient client.Client,
-	discordClient discord.Client,
+	apiReader client.Reader,
+	applications *controller.ApplicationResolver,
+	recorder record.EventRecorder,
+	auditSink audit.Sink,
+	workers WorkerPoolOptions,
 	logger logr.Logger,
-	publicKey ed25519.PublicKey,
-	listenAddr, namespace string,
+	listenAddr string,
 ) *DiscordWebhookServerRunner {
 	return &DiscordWebhookServerRunner{
-		k8sClient:     k8sClient,
-		discordClient: discordClient,
-		logger:        logger,
-		publicKey:     publicKey,
-		listenAddr:    listenAddr,
-		namespace:     namespace,
+		interactionHandler: newInteractionHandler(k8sClient, apiReader, recorder, logger, auditSink, workers),
+		applications:       applications,
+		listenAddr:         listenAddr,
 	}
 }
 
-func (r *DiscordWebhookServerRunner) verifyRequest(header http.Header, body []byte) (bool, error) {
+// verifyRequest reports whether the request is signed by any of the public keys.
+func verifyRequest(publicKeys []ed25519.PublicKey, header http.Header, body []byte) (bool, error) {
 	signatureEncoded := header.Get("X-Signature-Ed25519")
 	timestamp := header.Get("X-Signature-Timestamp")
 
@@ -67,104 +146,429 @@ func (r *DiscordWebhookServerRunner) verifyRequest(header http.Header, body []by
 		return false, err
 	}
 
-	return ed25519.Verify(r.publicKey, message, signature), nil
+	for _, publicKey := range publicKeys {
+		if ed25519.Verify(publicKey, message, signature) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// responseGate lets the tasks in the background wait until the initial
+// response to the interaction is sent, since the interaction can't be followed
+// up before then.
+type responseGate struct {
+	once sync.Once
+	done chan struct{}
+	sent bool
+}
+
+func newResponseGate() *responseGate {
+	return &responseGate{done: make(chan struct{})}
+}
+
+// open records whether the response has been sent and lets the waiters go.
+// Only the first call counts, so it can be deferred to open the gate as
+// unsent on any failure.
+func (g *responseGate) open(sent bool) {
+	g.once.Do(func() {
+		g.sent = sent
+		close(g.done)
+	})
 }
 
+// wait waits for the gate to open and reports whether the response has been
+// sent. It returns false if ctx is done first. A nil gate is always open.
+func (g *responseGate) wait(ctx context.Context) bool {
+	if g == nil {
+		return true
+	}
+	select {
+	case <-g.done:
+		return g.sent
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requestApplicationCommand is an application command interaction being
+// handled.
 type requestApplicationCommand struct {
-	Data      interface{} `json:"data"`
-	GuildID   string      `json:"guild_id"`
-	ChannelID string      `json:"channel_id"`
-	Token     string      `json:"token"`
-	ID        string      `json:"id"`
+	discord.Interaction
+
+	// responded is opened when the response to the interaction is sent.
+	responded *responseGate
+
+	// options are the options validated against the parameters of the
+	// matched action.
+	options map[string]interface{}
 }
 
-func (r *DiscordWebhookServerRunner) handleApplicationCommand(
+// optionValues returns the values of the options of the command. They are
+// validated and defaulted once validateOptions succeeds.
+func (req *requestApplicationCommand) optionValues() map[string]interface{} {
+	if req.options != nil {
+		return req.options
+	}
+	return req.Data.OptionValues()
+}
+
+// validateOptions validates the options of the command against the parameters
+// of the action.
+func (req *requestApplicationCommand) validateOptions(action *vahkanev1.DiscordInteractionAction) error {
+	options, err := controller.ValidateParameters(action.Parameters, req.Data.OptionValues())
+	if err != nil {
+		return err
+	}
+	req.options = options
+	return nil
+}
+
+// formatParameterError returns the message telling the invoker which options
+// are invalid.
+func formatParameterError(err *controller.ParameterError) string {
+	var b strings.Builder
+	b.WriteString(":x: invalid options:")
+	for _, problem := range err.Problems {
+		b.WriteString("\n- ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+func (r *interactionHandler) handleApplicationCommand(
 	w http.ResponseWriter,
-	body []byte,
+	interaction *discord.Interaction,
+	app *controller.Application,
+	responded *responseGate,
 ) error {
-	var req requestApplicationCommand
-	if err := json.Unmarshal(body, &req); err != nil {
-		return err
+	req := requestApplicationCommand{Interaction: *interaction, responded: responded}
+
+	// Discord requires a response within 3 seconds, so respond synchronously.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	di, err := fetchDiscordInteractionByGuildID(ctx, r.k8sClient, app.Key, req.GuildID)
+	if err != nil {
+		// Let the follow-up report the error.
+		r.logger.Error(err, "failed to fetch DiscordInteraction by guild id", "guild_id", req.GuildID)
+		return r.handleJobAction(w, &req, app, nil, nil)
+	}
+	if di.Spec.StatusCommand != nil && req.Data.GetName() == di.Spec.StatusCommand.GetName() {
+		return r.handleStatusCommand(ctx, w, &req, di)
 	}
+	return r.handleAction(ctx, w, &req, app, di)
+}
 
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// handleJobAction queues the run of the action in the background and defers
+// the response. di and action are nil if they are not found, in which case the
+// error is reported by the follow-up. The interaction is refused if the
+// workers are busy.
+func (r *interactionHandler) handleJobAction(
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	app *controller.Application,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+) error {
+	err := r.workers.submit(func(ctx context.Context) {
+		if !req.responded.wait(ctx) {
+			// Discord has not been told that the interaction is
+			// being processed, so the invoker will see it failed.
+			r.logger.Info("skip interaction whose response was not sent", "interaction_id", req.ID)
+			entry := newAuditEntry(req, di, action, audit.DecisionFailed)
+			entry.Reason = errResponseNotSent.Error()
+			r.writeAudit(entry)
+			return
+		}
+		var job types.NamespacedName
+		var queued bool
+		var err error
+		switch {
+		case di == nil:
+			err = errNoDiscordInteraction
+		case action == nil:
+			err = errNoActionMatched
+		default:
+			job, queued, err = queueJobByRequest(ctx, r.logger, r.k8sClient, r.apiReader, r.recorder, di, action, req)
+		}
+		if errors.Is(err, controller.ErrDuplicateInteraction) {
+			// Another replica has handled the interaction and will follow up.
+			r.logger.Info("skip duplicate interaction", "interaction_id", req.ID)
+			return
+		}
+		entry := newAuditEntry(req, di, action, audit.DecisionQueued)
+		switch {
+		case errors.Is(err, errNoActionMatched):
+			entry.Decision = audit.DecisionNoMatch
+		case errors.Is(err, controller.ErrTooManyJobs), errors.Is(err, controller.ErrAlreadyRunning),
+			errors.As(err, new(*controller.ParameterError)):
+			entry.Decision, entry.Reason = audit.DecisionRefused, err.Error()
+		case err != nil:
+			entry.Decision, entry.Reason = audit.DecisionFailed, err.Error()
+		default:
+			entry.JobName = job.Name
+		}
+		r.writeAudit(entry)
+
+		if err != nil {
+			r.logger.Error(err, "failed to queue job", "interaction_id", req.ID, "command", req.Data)
+			msg := ":x: failed to queue your job"
+			var paramErr *controller.ParameterError
+			switch {
+			case errors.Is(err, controller.ErrTooManyJobs):
+				msg = ":x: too many jobs are running; try again later"
+			case errors.As(err, &paramErr):
+				msg = formatParameterError(paramErr)
+			}
+			if err := app.Client.SendFollowupMessage(ctx, req.Token, msg); err != nil {
+				r.logger.Error(err, "failed to send followup message", "message", msg)
+			}
+			return
+		}
 		msg := ":ok: successfully queued your job"
-		if err := queueJobByRequest(ctx, r.logger, r.k8sClient, r.namespace, &req); err != nil {
-			r.logger.Error(err, "failed to queue job: "+string(body))
-			msg = ":x: failed to queue your job"
+		if queued {
+			msg = ":hourglass: your job is queued and will start when other jobs finish"
 		}
-		if err := r.discordClient.SendFollowupMessage(ctx, req.Token, msg); err != nil {
+		if action.ActionInline.Resource != nil {
+			// Only Jobs can be cancelled.
+			err = app.Client.SendFollowupMessage(ctx, req.Token, msg)
+		} else {
+			err = app.Client.SendFollowupMessageWithComponents(ctx, req.Token, msg, makeCancelButton(job))
+		}
+		if err != nil {
 			r.logger.Error(err, "failed to send followup message", "message", msg)
 		}
-	}()
+	})
+	if err != nil {
+		return r.refuseBusy(w, req, di, action, err)
+	}
 
-	return respondDeferred(w)
+	// The first follow-up replaces the deferred response, so whether the
+	// messages about queueing are ephemeral is decided here.
+	return respondDeferred(w, action != nil && action.Ephemeral != nil && action.Ephemeral.Queued)
+}
+
+// refuseBusy responds to the interaction that is refused because the workers
+// are busy.
+func (r *interactionHandler) refuseBusy(
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
+	err error,
+) error {
+	r.logger.Info("refused interaction because the workers are busy", "interaction_id", req.ID)
+	metrics.InteractionsShedTotal.Inc()
+	entry := newAuditEntry(req, di, action, audit.DecisionRefused)
+	entry.Reason = err.Error()
+	r.writeAudit(entry)
+	return respondMessage(w, ":x: vahkane is busy; try again later", true)
+}
+
+// handleStatusCommand responds to the built-in status command with the list of
+// the runs in progress.
+func (r *interactionHandler) handleStatusCommand(
+	ctx context.Context,
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	di *vahkanev1.DiscordInteraction,
+) error {
+	entry := newAuditEntry(req, di, nil, audit.DecisionStatus)
+	defer r.writeAudit(entry)
+
+	jobs, err := listActiveJobs(ctx, r.k8sClient, di.GetName(), di.GetNamespace())
+	if err != nil {
+		r.logger.Error(err, "failed to list active jobs")
+		entry.Decision, entry.Reason = audit.DecisionFailed, err.Error()
+		return respondMessage(w, ":x: failed to list the runs in progress", true)
+	}
+
+	return respondMessage(w, formatActiveJobs(jobs, time.Now()), true)
+}
+
+// handleAction handles the command according to the kind of the matched
+// action of di.
+func (r *interactionHandler) handleAction(
+	ctx context.Context,
+	w http.ResponseWriter,
+	req *requestApplicationCommand,
+	app *controller.Application,
+	di *vahkanev1.DiscordInteraction,
+) error {
+	action, err := matchActions(di.Spec.Actions, req.RawData)
+	if err != nil {
+		metrics.ActionMissesTotal.WithLabelValues(di.GetName()).Inc()
+		r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonNoActionMatched,
+			"No action matched the command from user %s", req.Invoker().ID)
+		return r.handleJobAction(w, req, app, di, nil)
+	}
+
+	// Invalid invocations are refused before they count toward the rate
+	// limits.
+	var paramErr *controller.ParameterError
+	if err := req.validateOptions(action); errors.As(err, &paramErr) {
+		r.recorder.Eventf(di, corev1.EventTypeWarning, eventReasonInvalidOptions,
+			"Refused to run action %s for user %s: %v", action.Name, req.Invoker().ID, err)
+		entry := newAuditEntry(req, di, action, audit.DecisionRefused)
+		entry.Reason = err.Error()
+		r.writeAudit(entry)
+		return respondMessage(w, formatParameterError(paramErr), true)
+	}
+
+	limits := actionRateLimits(di, action, req.Invoker().ID)
+	if allowed, wait := r.limiter.allow(time.Now(), limits...); !allowed {
+		metrics.ActionsRateLimitedTotal.WithLabelValues(di.GetName(), action.Name).Inc()
+		r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonRateLimited,
+			"Action %s was refused for user %s by the rate limit", action.Name, req.Invoker().ID)
+		wait = time.Duration(math.Ceil(wait.Seconds())) * time.Second
+		entry := newAuditEntry(req, di, action, audit.DecisionRefused)
+		entry.Reason = "rate limited"
+		r.writeAudit(entry)
+		return respondMessage(w, fmt.Sprintf(
+			":hourglass: this action was run too often; try again in %s", wait), true)
+	}
+
+	metrics.ActionMatchesTotal.WithLabelValues(di.GetName(), action.Name).Inc()
+	r.recorder.Eventf(di, corev1.EventTypeNormal, eventReasonActionMatched,
+		"Action %s matched the command from user %s", action.Name, req.Invoker().ID)
+
+	inline := &action.ActionInline
+	switch {
+	case inline.Operation == nil && inline.HTTP == nil && inline.Reply == nil:
+		return r.handleJobAction(w, req, app, di, action)
+	case inline.Operation != nil:
+		return r.handleOperationAction(ctx, w, req, di, action)
+	case inline.Reply != nil:
+		return r.handleReplyAction(ctx, w, req, di, action)
+	default:
+		return r.handleHTTPAction(w, req, app, di, action)
+	}
+}
+
+// handleInteraction writes the response to the interaction to w. It returns
+// the type of the interaction for metrics. The caller opens responded once the
+// response is sent, and the tasks in the background wait for it before they
+// run.
+func (r *interactionHandler) handleInteraction(
+	w http.ResponseWriter,
+	interaction *discord.Interaction,
+	app *controller.Application,
+	responded *responseGate,
+) (string, error) {
+	switch interaction.Type {
+	case discord.InteractionTypePing:
+		return "ping", respondJSON(w, map[string]int{"type": 1 /* PONG */})
+
+	case discord.InteractionTypeApplicationCommand:
+		return "application_command", r.handleApplicationCommand(w, interaction, app, responded)
+
+	case discord.InteractionTypeMessageComponent:
+		return "message_component", r.handleMessageComponent(w, interaction, app)
+
+	default:
+		r.logger.Info("unexpected request", "type", interaction.Type)
+		return "unknown", errUnsupportedInteraction
+	}
 }
 
 func (r *DiscordWebhookServerRunner) handleWebhook(
 	w http.ResponseWriter,
 	req *http.Request,
+	appKey types.NamespacedName,
 ) error {
 	// cf. https://discord.com/developers/docs/interactions/overview
 
+	interactionType, result := "unknown", "error"
+	defer func() {
+		metrics.WebhookRequestsTotal.WithLabelValues(interactionType, result).Inc()
+	}()
+
 	defer func() {
 		_ = req.Body.Close()
 	}()
-	body, err := io.ReadAll(req.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, discord.MaxInteractionSize))
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			result = "too_large"
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return nil
+		}
+		return err
+	}
+
+	app, err := r.applications.Resolve(req.Context(), appKey)
 	if err != nil {
+		if errors.Is(err, controller.ErrApplicationNotFound) {
+			// Respond as if the signature were bad so that the existence of
+			// applications isn't revealed to unauthenticated clients.
+			result = "not_found"
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil
+		}
 		return err
 	}
-	r.logger.Info("discord webhook request: " + string(body))
 
-	verified, err := r.verifyRequest(req.Header, body)
+	verified, err := verifyRequest(app.PublicKeys, req.Header, body)
 	if err != nil {
+		metrics.SignatureVerificationFailuresTotal.Inc()
 		return err
 	}
 	if !verified {
+		metrics.SignatureVerificationFailuresTotal.Inc()
+		result = "unauthorized"
 		w.WriteHeader(http.StatusUnauthorized)
 		return nil
 	}
 
-	var root map[string]interface{}
-	if err := json.Unmarshal(body, &root); err != nil {
-		return err
-	}
-	requestType, ok1 := root["type"]
-	requestTypeParsed, ok2 := requestType.(float64)
-	if !ok1 || !ok2 {
-		return errors.New("type not found in the request")
+	interaction, err := discord.ParseInteraction(body)
+	if err != nil {
+		r.logger.Info("invalid interaction", "error", err.Error())
+		result = "invalid"
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
 	}
 
-	switch int(requestTypeParsed) {
-	case 1: // PING
-		if err := respondJSON(w, map[string]int{"type": 1 /* PONG */}); err != nil {
-			return err
-		}
-
-	case 2: // APPLICATION_COMMAND
-		if err := r.handleApplicationCommand(w, body); err != nil {
-			return err
-		}
-
-	default:
-		r.logger.Info("unexpected request", "body", body)
+	responded := newResponseGate()
+	defer responded.open(false)
+	interactionType, err = r.handleInteraction(w, interaction, app, responded)
+	if errors.Is(err, errUnsupportedInteraction) {
+		result = "unsupported"
 		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
+	// Send the response before the tasks in the background follow it up.
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		// The status has already been written.
+		r.logger.Error(err, "failed to send the response", "interaction_id", interaction.ID)
+		return nil
+	}
+	responded.open(true)
+	result = "ok"
 	return nil
 }
 
 func (r *DiscordWebhookServerRunner) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/webhook", func(w http.ResponseWriter, req *http.Request) {
-		if err := r.handleWebhook(w, req); err != nil {
+		if err := r.handleWebhook(w, req, types.NamespacedName{}); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			r.logger.Error(err, "failed to handle webhook request")
 			return
 		}
 	})
+	mux.HandleFunc("/webhook/{namespace}/{name}", func(w http.ResponseWriter, req *http.Request) {
+		appKey := types.NamespacedName{Namespace: req.PathValue("namespace"), Name: req.PathValue("name")}
+		if err := r.handleWebhook(w, req, appKey); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			r.logger.Error(err, "failed to handle webhook request", "application", appKey)
+			return
+		}
+	})
 
 	srv := http.Server{
 		Addr:           r.listenAddr,
@@ -174,6 +578,11 @@ func (r *DiscordWebhookServerRunner) Start(ctx context.Context) error {
 		Handler:        mux,
 	}
 
+	listener, err := net.Listen("tcp", r.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
@@ -181,29 +590,48 @@ func (r *DiscordWebhookServerRunner) Start(ctx context.Context) error {
 	go func() {
 		defer wg.Done()
 		r.logger.Info("starting discord webhook server", "addr", r.listenAddr)
-		if err := srv.ListenAndServe(); err != nil {
-			r.logger.Error(err, "failed to start http server")
+		r.listening.Store(true)
+		defer r.listening.Store(false)
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.logger.Error(err, "failed to serve http server")
 		}
 	}()
 
 	<-ctx.Done()
 
-	ctxShutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Shutdown waits for the handlers, which may queue interactions, so the
+	// workers are drained after it with their own budget.
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctxShutdown); err != nil {
 		r.logger.Error(err, "failed to shutdown http server")
 	}
+	r.drainWorkers(nil)
 
 	return nil
 }
 
-func (r DiscordWebhookServerRunner) NeedLeaderElection() bool {
-	return true
+// NeedLeaderElection returns false so that every replica serves the webhook.
+// Runs are deduplicated by the interaction ID, and runs of an action that is
+// not Concurrent are serialized by controller.AcquireJobLock.
+func (r *DiscordWebhookServerRunner) NeedLeaderElection() bool {
+	return false
 }
 
+// ReadyzCheck reports whether the webhook server is listening.
+func (r *DiscordWebhookServerRunner) ReadyzCheck(_ *http.Request) error {
+	if !r.listening.Load() {
+		return errors.New("discord webhook server is not listening")
+	}
+	return nil
+}
+
+// fetchDiscordInteractionByGuildID returns the DiscordInteraction for the
+// guild served by the application identified by appKey.
 func fetchDiscordInteractionByGuildID(
 	ctx context.Context,
 	k8sClient client.Client,
+	appKey types.NamespacedName,
 	guildID string,
 ) (*vahkanev1.DiscordInteraction, error) {
 	var diList vahkanev1.DiscordInteractionList
@@ -218,10 +646,85 @@ func fetchDiscordInteractionByGuildID(
 	); err != nil {
 		return nil, err
 	}
-	if len(diList.Items) != 1 {
-		return nil, fmt.Errorf("unexpected number of DiscordInteractions: %d", len(diList.Items))
+	items := []*vahkanev1.DiscordInteraction{}
+	for i := range diList.Items {
+		if controller.ApplicationKey(&diList.Items[i]) == appKey {
+			items = append(items, &diList.Items[i])
+		}
+	}
+	if len(items) != 1 {
+		return nil, fmt.Errorf("unexpected number of DiscordInteractions: %d", len(items))
 	}
-	return &diList.Items[0], nil
+	return items[0], nil
+}
+
+// listActiveJobs returns the unfinished Jobs created for the DiscordInteraction.
+func listActiveJobs(
+	ctx context.Context,
+	k8sClient client.Client,
+	diName, namespace string,
+) ([]batchv1.Job, error) {
+	var jobList batchv1.JobList
+	if err := k8sClient.List(
+		ctx,
+		&jobList,
+		client.InNamespace(namespace),
+		client.HasLabels{controller.LabelKeyJob},
+	); err != nil {
+		return nil, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+
+	jobs := []batchv1.Job{}
+	for _, job := range jobList.Items {
+		if job.GetAnnotations()[controller.AnnotKeyDiscordInteraction] != diName ||
+			controller.IsJobFinished(&job) || !job.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreationTimestamp.Before(&jobs[j].CreationTimestamp)
+	})
+	return jobs, nil
+}
+
+// formatActiveJobs returns the message listing the runs in progress.
+func formatActiveJobs(jobs []batchv1.Job, now time.Time) string {
+	if len(jobs) == 0 {
+		return "no runs in progress"
+	}
+
+	var buf strings.Builder
+	buf.WriteString("runs in progress:")
+	for _, job := range jobs {
+		startTime := job.GetCreationTimestamp()
+		if job.Status.StartTime != nil {
+			startTime = *job.Status.StartTime
+		}
+		state := "pending"
+		if _, ok := job.GetAnnotations()[controller.AnnotKeyQueued]; ok {
+			state = "queued"
+		} else if job.Status.Active > 0 {
+			state = "running"
+		}
+
+		annots := job.GetAnnotations()
+		user := "unknown user"
+		if userID := annots[controller.AnnotKeyUserID]; userID != "" {
+			user = fmt.Sprintf("<@%s>", userID)
+		}
+
+		fmt.Fprintf(
+			&buf,
+			"\n- `%s` by %s: %s for %s",
+			annots[controller.AnnotKeyAction],
+			user,
+			state,
+			now.Sub(startTime.Time).Round(time.Second),
+		)
+	}
+	// Discord refuses messages over the limit.
+	return discord.TruncateContent(buf.String())
 }
 
 func respondJSON(w http.ResponseWriter, v interface{}) error {
@@ -236,104 +739,100 @@ func respondJSON(w http.ResponseWriter, v interface{}) error {
 	return nil
 }
 
-func respondDeferred(w http.ResponseWriter) error {
+func respondDeferred(w http.ResponseWriter, ephemeral bool) error {
 	var resp struct {
 		Type int `json:"type"`
+		Data *struct {
+			Flags int `json:"flags"`
+		} `json:"data,omitempty"`
 	}
 	resp.Type = 5
+	if ephemeral {
+		resp.Data = &struct {
+			Flags int `json:"flags"`
+		}{Flags: discord.MessageFlagEphemeral}
+	}
 	return respondJSON(w, &resp)
 }
 
-func doesJobAlreadyExist(
-	ctx context.Context,
-	k8sClient client.Client,
-	action *vahkanev1.DiscordInteractionAction,
-	diName, namespace string,
-) (bool, error) {
-	var job batchv1.Job
-	if err := k8sClient.Get(
-		ctx,
-		types.NamespacedName{Name: makeJobName(diName, action), Namespace: namespace},
-		&job,
-	); err != nil {
-		if k8serrors.IsNotFound(err) {
-			return false, nil
-		}
-		return false, fmt.Errorf("failed to get Job: %w", err)
+// respondMessage responds with the message. The content may contain the input
+// of the invoker, so it is truncated to the maximum length and its mentions
+// notify nobody. So do those of respondUpdateMessage.
+func respondMessage(w http.ResponseWriter, content string, ephemeral bool) error {
+	var resp struct {
+		Type int `json:"type"`
+		Data struct {
+			Content         string                   `json:"content"`
+			Flags           int                      `json:"flags,omitempty"`
+			AllowedMentions *discord.AllowedMentions `json:"allowed_mentions"`
+		} `json:"data"`
+	}
+	resp.Type = 4 // CHANNEL_MESSAGE_WITH_SOURCE
+	resp.Data.Content = discord.TruncateContent(content)
+	resp.Data.AllowedMentions = discord.NoMentions()
+	if ephemeral {
+		resp.Data.Flags = discord.MessageFlagEphemeral
 	}
-	return true, nil
+	return respondJSON(w, &resp)
 }
 
-func createJobForAction(
-	ctx context.Context,
-	k8sClient client.Client,
-	action *vahkanev1.DiscordInteractionAction,
-	diName, namespace, interactionToken string,
-) error {
-	var job batchv1.Job
-
-	job.Spec = action.ActionInline.JobTemplate.Spec
-	job.ObjectMeta = action.ActionInline.JobTemplate.ObjectMeta
-	job.ObjectMeta.Namespace = namespace
-	job.ObjectMeta.Name = makeJobName(diName, action)
-	job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
-
-	labels := job.GetLabels()
-	if labels == nil {
-		labels = map[string]string{}
-	}
-	labels[controller.LabelKeyJob] = "true"
-	job.SetLabels(labels)
-
-	annots := job.GetAnnotations()
-	if annots == nil {
-		annots = map[string]string{}
-	}
-	annots[annotKeyDiscordInteraction] = diName
-	annots[annotKeyAction] = action.Name
-	annots[controller.AnnotKeyDiscordInteractionToken] = interactionToken
-	job.SetAnnotations(annots)
-
-	if err := k8sClient.Create(ctx, &job); err != nil {
-		return fmt.Errorf(
-			"failed to create job: %s: %s: %w",
-			job.ObjectMeta.Name,
-			job.ObjectMeta.Namespace,
-			err,
-		)
+func respondUpdateMessage(w http.ResponseWriter, content string) error {
+	var resp struct {
+		Type int `json:"type"`
+		Data struct {
+			Content         string                   `json:"content"`
+			Components      []interface{}            `json:"components"`
+			AllowedMentions *discord.AllowedMentions `json:"allowed_mentions"`
+		} `json:"data"`
 	}
-	return nil
+	resp.Type = 7 // UPDATE_MESSAGE
+	resp.Data.Content = discord.TruncateContent(content)
+	resp.Data.AllowedMentions = discord.NoMentions()
+	resp.Data.Components = []interface{}{}
+	return respondJSON(w, &resp)
 }
 
+// queueJobByRequest starts the run of the action matched by the command. The
+// options of the command must have been validated against the action.
 func queueJobByRequest(
 	ctx context.Context,
 	logger logr.Logger,
 	k8sClient client.Client,
-	namespace string,
+	apiReader client.Reader,
+	recorder record.EventRecorder,
+	di *vahkanev1.DiscordInteraction,
+	action *vahkanev1.DiscordInteractionAction,
 	req *requestApplicationCommand,
-) error {
-	di, err := fetchDiscordInteractionByGuildID(ctx, k8sClient, req.GuildID)
-	if err != nil {
-		return fmt.Errorf("failed to fetch DiscordInteraction by guild id: %w", err)
-	}
-
-	action, err := matchActions(di.Spec.Actions, req.Data)
-	if err != nil {
-		return fmt.Errorf("failed to match actions: %w", err)
-	}
+) (types.NamespacedName, bool, error) {
 	logger.Info("action queued", "action.Name", action.Name)
 
-	exist, err := doesJobAlreadyExist(ctx, k8sClient, action, di.Name, namespace)
-	if err != nil {
-		return fmt.Errorf("failed to check if Job already exists: %w", err)
-	}
-	if exist {
-		return errors.New("already running")
+	jobName, queued, err := controller.StartRun(ctx, k8sClient, apiReader, di, action, newRunInvocation(req))
+	switch {
+	case errors.Is(err, controller.ErrTooManyJobs):
+		recorder.Eventf(di, corev1.EventTypeWarning, eventReasonTooManyJobs,
+			"Refused to run action %s because %d Jobs are running", action.Name, *di.Spec.MaxConcurrentJobs)
+		return types.NamespacedName{}, false, err
+	case errors.Is(err, controller.ErrAlreadyRunning):
+		recorder.Eventf(di, corev1.EventTypeWarning, eventReasonAlreadyRunning,
+			"Refused to run action %s because it is already running", action.Name)
+		return types.NamespacedName{}, false, err
+	case err != nil:
+		return types.NamespacedName{}, false, fmt.Errorf("failed to create Job for Action: %w", err)
 	}
+	recorder.Eventf(di, corev1.EventTypeNormal, eventReasonJobCreated,
+		"Created Job %s for action %s", jobName, action.Name)
 
-	if err := createJobForAction(ctx, k8sClient, action, di.Name, namespace, req.Token); err != nil {
-		return fmt.Errorf("failed to create Job for Action: %w", err)
-	}
+	return types.NamespacedName{Name: jobName, Namespace: di.Namespace}, queued, nil
+}
 
-	return nil
+// newRunInvocation returns the invocation of the run triggered by the
+// application command.
+func newRunInvocation(req *requestApplicationCommand) *controller.RunInvocation {
+	return &controller.RunInvocation{
+		InteractionID: req.ID,
+		Token:         req.Token,
+		UserID:        req.Invoker().ID,
+		UserName:      req.Invoker().Username,
+		Options:       controller.OptionStrings(req.optionValues()),
+	}
 }
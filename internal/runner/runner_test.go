@@ -0,0 +1,271 @@
+package runner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"github.com/ushitora-anqou/vahkane/internal/k8stest"
+	"github.com/ushitora-anqou/vahkane/internal/metrics"
+	"go.uber.org/mock/gomock"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestFormatActiveJobs(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if msg := formatActiveJobs(nil, now); msg != "no runs in progress" {
+		t.Errorf("formatActiveJobs returns unexpected value: %s", msg)
+	}
+
+	var job1, job2 batchv1.Job
+	job1.SetCreationTimestamp(metav1.NewTime(now.Add(-90 * time.Second)))
+	job1.SetAnnotations(map[string]string{
+		controller.AnnotKeyAction: "deploy",
+		controller.AnnotKeyUserID: "1234",
+	})
+	job1.Status.Active = 1
+	job2.SetCreationTimestamp(metav1.NewTime(now.Add(-5 * time.Second)))
+	job2.SetAnnotations(map[string]string{controller.AnnotKeyAction: "build"})
+
+	msg := formatActiveJobs([]batchv1.Job{job1, job2}, now)
+	expected := "runs in progress:\n" +
+		"- `deploy` by <@1234>: running for 1m30s\n" +
+		"- `build` by unknown user: pending for 5s"
+	if msg != expected {
+		t.Errorf("formatActiveJobs returns unexpected value: %s", msg)
+	}
+
+	jobs := make([]batchv1.Job, 100)
+	for i := range jobs {
+		jobs[i] = job1
+	}
+	if msg := formatActiveJobs(jobs, now); len(msg) > discord.MaxContentLength {
+		t.Errorf("formatActiveJobs should fit in a message: %d", len(msg))
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	oldPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPublicKey, newPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":1}`)
+	timestamp := "1700000000"
+	header := http.Header{}
+	header.Set("X-Signature-Ed25519",
+		hex.EncodeToString(ed25519.Sign(newPrivateKey, append([]byte(timestamp), body...))))
+	header.Set("X-Signature-Timestamp", timestamp)
+
+	tests := []struct {
+		name       string
+		publicKeys []ed25519.PublicKey
+		expected   bool
+	}{
+		{"signed by the only key", []ed25519.PublicKey{newPublicKey}, true},
+		{"signed by one of the keys", []ed25519.PublicKey{oldPublicKey, newPublicKey}, true},
+		{"not signed by any key", []ed25519.PublicKey{oldPublicKey}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := verifyRequest(tt.publicKeys, header, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verified != tt.expected {
+				t.Errorf("verifyRequest returns %v", verified)
+			}
+		})
+	}
+}
+
+func TestHandleWebhookUnknownApplication(t *testing.T) {
+	k8sClient := k8stest.NewFakeClientBuilder(t).Build()
+	runner := NewDiscordWebhookServerRunner(
+		k8sClient,
+		k8sClient,
+		controller.NewApplicationResolver(k8sClient, nil, types.NamespacedName{}, nil),
+		&record.FakeRecorder{},
+		nil,
+		WorkerPoolOptions{},
+		logr.Discard(),
+		"",
+	)
+
+	// Unknown applications are indistinguishable from bad signatures.
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/ns/missing", strings.NewReader(`{"type":1}`))
+	if err := runner.handleWebhook(w, req, types.NamespacedName{Namespace: "ns", Name: "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestHandleActionMetrics(t *testing.T) {
+	di := &vahkanev1.DiscordInteraction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "di",
+			Namespace: "ns",
+			Labels:    map[string]string{controller.LabelKeyDiscordGuildID: "guild"},
+		},
+		Spec: vahkanev1.DiscordInteractionSpec{
+			Actions: []vahkanev1.DiscordInteractionAction{{
+				Name:    "deploy",
+				Pattern: "name: deploy",
+				ActionInline: vahkanev1.DiscordInteractionActionInline{
+					JobTemplate: &batchv1.JobTemplateSpec{},
+				},
+			}},
+		},
+	}
+	k8sClient := k8stest.NewFakeClientBuilder(t).
+		WithObjects(di).
+		WithStatusSubresource(&vahkanev1.InteractionRun{}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	matched := metrics.ActionMatchesTotal.WithLabelValues("di", "deploy")
+	missed := metrics.ActionMissesTotal.WithLabelValues("di")
+	created := metrics.JobsCreatedTotal.WithLabelValues("di", "deploy")
+	matchedBefore, missedBefore, createdBefore :=
+		testutil.ToFloat64(matched), testutil.ToFloat64(missed), testutil.ToFloat64(created)
+
+	newRequest := func(id, data string) *requestApplicationCommand {
+		return &requestApplicationCommand{Interaction: discord.Interaction{
+			ID:      id,
+			GuildID: "guild",
+			Token:   "token-" + id,
+			User:    &discord.User{ID: "user-id", Username: "user"},
+			Data:    &discord.InteractionData{},
+			RawData: json.RawMessage(data),
+		}}
+	}
+	discordClient := discord.NewMockClient(gomock.NewController(t))
+	discordClient.EXPECT().SendFollowupMessage(gomock.Any(), "token-2", gomock.Any()).Return(nil)
+	discordClient.EXPECT().SendFollowupMessageWithComponents(gomock.Any(), "token-1", gomock.Any(), gomock.Any()).Return(nil)
+	app := &controller.Application{Client: discordClient}
+	handler := newInteractionHandler(k8sClient, k8sClient, recorder, logr.Discard(), nil, WorkerPoolOptions{})
+
+	ctx := context.Background()
+	if err := handler.handleAction(ctx, httptest.NewRecorder(), newRequest("2", `{"name":"build"}`), app, di); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.handleAction(ctx, httptest.NewRecorder(), newRequest("1", `{"name":"deploy"}`), app, di); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.workers.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	k8stest.ExpectEventReasons(t, recorder, eventReasonNoActionMatched, eventReasonActionMatched, eventReasonJobCreated)
+
+	if got := testutil.ToFloat64(matched) - matchedBefore; got != 1 {
+		t.Errorf("the match should be counted once: %v", got)
+	}
+	if got := testutil.ToFloat64(missed) - missedBefore; got != 1 {
+		t.Errorf("the miss should be counted once: %v", got)
+	}
+	if got := testutil.ToFloat64(created) - createdBefore; got != 1 {
+		t.Errorf("the created Job should be counted once: %v", got)
+	}
+}
+
+func TestRespondDeferred(t *testing.T) {
+	for _, tt := range []struct {
+		ephemeral bool
+		expected  string
+	}{
+		{false, `{"type":5}`},
+		{true, `{"type":5,"data":{"flags":64}}`},
+	} {
+		w := httptest.NewRecorder()
+		if err := respondDeferred(w, tt.ephemeral); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != tt.expected {
+			t.Errorf("unexpected response: %s", w.Body.String())
+		}
+	}
+}
+
+func TestRespondMessage(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := respondMessage(w, "@everyone <@1234>", true); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":4,"data":{"content":"@everyone \u003c@1234\u003e","flags":64,"allowed_mentions":{"parse":[]}}}`
+	if w.Body.String() != expected {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := respondMessage(w, strings.Repeat("x", discord.MaxContentLength+1), true); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Data struct {
+			Content         string                   `json:"content"`
+			AllowedMentions *discord.AllowedMentions `json:"allowed_mentions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Content) > discord.MaxContentLength || resp.Data.AllowedMentions == nil {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	req := &requestApplicationCommand{Interaction: discord.Interaction{Data: &discord.InteractionData{
+		Name:    "deploy",
+		Options: []discord.InteractionDataOption{{Name: "env", Value: "dev"}},
+	}}}
+	action := &vahkanev1.DiscordInteractionAction{
+		Name: "deploy",
+		Parameters: []vahkanev1.DiscordInteractionActionParameter{
+			{Name: "env", Enum: []string{"staging", "prod"}},
+			{Name: "ref", Required: true},
+		},
+	}
+
+	var paramErr *controller.ParameterError
+	if err := req.validateOptions(action); !errors.As(err, &paramErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := ":x: invalid options:\n- option `env` must be one of staging, prod\n- option `ref` is required"
+	if msg := formatParameterError(paramErr); msg != expected {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	action.Parameters[1].Required = false
+	action.Parameters[0].Enum = append(action.Parameters[0].Enum, "dev")
+	action.Parameters[1].Default = ptr.To("main")
+	if err := req.validateOptions(action); err != nil {
+		t.Fatal(err)
+	}
+	if options := req.optionValues(); options["env"] != "dev" || options["ref"] != "main" {
+		t.Errorf("unexpected options: %v", options)
+	}
+}
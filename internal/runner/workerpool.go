@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WorkerPoolOptions configures the workers that process the interactions in
+// the background after they are responded to, such as creating Jobs.
+type WorkerPoolOptions struct {
+	// Workers is the number of tasks processed concurrently.
+	Workers int
+	// QueueSize is the number of tasks waiting for a worker. Interactions
+	// are refused as busy while the queue is full.
+	QueueSize int
+	// TaskTimeout is the timeout of each task. Tasks with their own
+	// timeouts, such as calling HTTP actions, are not limited by it.
+	TaskTimeout time.Duration
+	// DrainTimeout is how long the tasks are waited for on shutdown.
+	DrainTimeout time.Duration
+}
+
+// DefaultWorkerPoolOptions is used for the zero fields of WorkerPoolOptions.
+var DefaultWorkerPoolOptions = WorkerPoolOptions{
+	Workers:      8,
+	QueueSize:    64,
+	TaskTimeout:  5 * time.Second,
+	DrainTimeout: 20 * time.Second,
+}
+
+var errBusy = errors.New("busy")
+
+// poolTask is a task queued in workerPool.
+type poolTask struct {
+	run     func(context.Context)
+	timeout time.Duration
+}
+
+// workerPool runs tasks on a fixed number of goroutines. The goroutines are
+// started by the first task, so that a pool that is never used costs nothing.
+type workerPool struct {
+	opts  WorkerPoolOptions
+	tasks chan poolTask
+	once  sync.Once
+	wg    sync.WaitGroup
+
+	// mu guards closed so that no task is submitted after tasks is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newWorkerPool(opts WorkerPoolOptions) *workerPool {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkerPoolOptions.Workers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultWorkerPoolOptions.QueueSize
+	}
+	if opts.TaskTimeout <= 0 {
+		opts.TaskTimeout = DefaultWorkerPoolOptions.TaskTimeout
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultWorkerPoolOptions.DrainTimeout
+	}
+	return &workerPool{
+		opts:  opts,
+		tasks: make(chan poolTask, opts.QueueSize),
+	}
+}
+
+// submit queues the task without blocking. The task is given a context that
+// expires after TaskTimeout. It returns errBusy if the queue is full or the
+// pool is drained.
+func (p *workerPool) submit(task func(context.Context)) error {
+	return p.submitWithTimeout(p.opts.TaskTimeout, task)
+}
+
+// submitWithTimeout is like submit, but the context of the task expires after
+// timeout instead of TaskTimeout.
+func (p *workerPool) submitWithTimeout(timeout time.Duration, task func(context.Context)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errBusy
+	}
+	p.once.Do(p.startWorkers)
+	select {
+	case p.tasks <- poolTask{run: task, timeout: timeout}:
+		return nil
+	default:
+		return errBusy
+	}
+}
+
+func (p *workerPool) startWorkers() {
+	for i := 0; i < p.opts.Workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				p.run(task)
+			}
+		}()
+	}
+}
+
+func (p *workerPool) run(task poolTask) {
+	// The tasks are not cancelled on drain so that queued interactions are
+	// followed up during a rollout.
+	ctx, cancel := context.WithTimeout(context.Background(), task.timeout)
+	defer cancel()
+	task.run(ctx)
+}
+
+// drain refuses new tasks and waits for the queued and running tasks to
+// finish. It returns ctx.Err() if ctx is done first.
+func (p *workerPool) drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+
+	return waitContext(ctx, p.wg.Wait)
+}
+
+// waitContext calls wait and returns when it returns. It returns ctx.Err() if
+// ctx is done first.
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	vahkanev1 "github.com/ushitora-anqou/vahkane/api/v1"
+	"github.com/ushitora-anqou/vahkane/internal/audit"
+	"github.com/ushitora-anqou/vahkane/internal/controller"
+	"github.com/ushitora-anqou/vahkane/internal/discord"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(WorkerPoolOptions{Workers: 1, QueueSize: 1, TaskTimeout: time.Second})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var finished atomic.Int32
+	if err := pool.submit(func(context.Context) {
+		close(started)
+		<-release
+		finished.Add(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The second task waits in the queue, and the third one is shed.
+	if err := pool.submit(func(ctx context.Context) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("the task should have a deadline")
+		}
+		finished.Add(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.submit(func(context.Context) {}); !errors.Is(err, errBusy) {
+		t.Errorf("the task should be shed: %v", err)
+	}
+
+	// Draining waits for the queued tasks.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain should time out while a task is running: %v", err)
+	}
+	close(release)
+	if err := pool.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := finished.Load(); n != 2 {
+		t.Errorf("unexpected number of finished tasks: %d", n)
+	}
+
+	if err := pool.submit(func(context.Context) {}); !errors.Is(err, errBusy) {
+		t.Errorf("tasks should be refused after drain: %v", err)
+	}
+}
+
+func TestWorkerPoolDefaults(t *testing.T) {
+	// The zero options take the defaults, so that the first tasks are not
+	// shed before the workers start.
+	pool := newWorkerPool(WorkerPoolOptions{})
+	if pool.opts != DefaultWorkerPoolOptions {
+		t.Errorf("unexpected options: %+v", pool.opts)
+	}
+
+	done := make(chan time.Duration, 1)
+	if err := pool.submitWithTimeout(time.Hour, func(ctx context.Context) {
+		deadline, _ := ctx.Deadline()
+		done <- time.Until(deadline)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if left := <-done; left <= DefaultWorkerPoolOptions.TaskTimeout {
+		t.Errorf("the task should have its own timeout: %v", left)
+	}
+	if err := pool.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleActionBusy(t *testing.T) {
+	handler := newInteractionHandler(nil, nil, &record.FakeRecorder{}, logr.Discard(), nil, WorkerPoolOptions{})
+	if err := handler.workers.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := &requestApplicationCommand{}
+	if err := handler.handleJobAction(w, req, &controller.Application{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "busy") || !strings.Contains(body, `"type":4`) {
+		t.Errorf("unexpected response: %s", body)
+	}
+
+	// HTTP actions run on the workers too.
+	w = httptest.NewRecorder()
+	action := &vahkanev1.DiscordInteractionAction{
+		Name: "http",
+		ActionInline: vahkanev1.DiscordInteractionActionInline{
+			HTTP: &vahkanev1.DiscordInteractionActionHTTP{URL: "http://example.com"},
+		},
+	}
+	if err := handler.handleHTTPAction(w, req, &controller.Application{}, nil, action); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "busy") || !strings.Contains(body, `"type":4`) {
+		t.Errorf("unexpected response: %s", body)
+	}
+}
+
+func TestResponseGate(t *testing.T) {
+	ctx := context.Background()
+
+	gate := newResponseGate()
+	gate.open(true)
+	gate.open(false)
+	if !gate.wait(ctx) {
+		t.Error("only the first open should count")
+	}
+	if !(*responseGate)(nil).wait(ctx) {
+		t.Error("a nil gate should be open")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if newResponseGate().wait(cancelled) {
+		t.Error("the response should not be sent if the context is done first")
+	}
+
+	// The run is not started if the response fails to be sent.
+	var auditLog bytes.Buffer
+	handler := newInteractionHandler(nil, nil, &record.FakeRecorder{}, logr.Discard(),
+		audit.NewWriterSink(&auditLog), WorkerPoolOptions{})
+	gate = newResponseGate()
+	req := &requestApplicationCommand{Interaction: discord.Interaction{ID: "1234"}, responded: gate}
+	if err := handler.handleJobAction(httptest.NewRecorder(), req, &controller.Application{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	gate.open(false)
+	if err := handler.workers.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if log := auditLog.String(); !strings.Contains(log, `"decision":"failed"`) ||
+		!strings.Contains(log, errResponseNotSent.Error()) {
+		t.Errorf("unexpected audit log: %s", log)
+	}
+}